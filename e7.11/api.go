@@ -0,0 +1,252 @@
+/* JSON resource API for the inventory database.
+GET    /items         list items (filters and paging: see list.go)
+POST   /items         create an item ({"name": "shirts", "price": 15}); 409 if it exists
+GET    /items/{name}  read one item
+PUT    /items/{name}  create or update an item ({"price": 15, "quantity": 3})
+DELETE /items/{name}  delete an item
+PUT only changes the fields present in the body; price is required to create.
+Items carry an ETag; see etag.go for conditional requests.
+Errors are returned as {"status": 404, "error": "no such item: \"hats\""} */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxBodyBytes limits the size of JSON request bodies
+const maxBodyBytes = 1 << 20
+
+// itemInput is the accepted request body for PUT /items/{name}.
+// Nil fields are left unchanged.
+type itemInput struct {
+	SKU         *string   `json:"sku"`
+	Description *string   `json:"description"`
+	Price       *dollars  `json:"price"`
+	Quantity    *int64    `json:"quantity"`
+	Reorder     *int64    `json:"reorder_level"`
+	Unit        *string   `json:"unit"`
+	Category    *string   `json:"category"`
+	Tags        *[]string `json:"tags"`
+}
+
+// apply copies the fields set in in to it
+func (in itemInput) apply(it *item) {
+	if in.SKU != nil {
+		it.SKU = *in.SKU
+	}
+	if in.Description != nil {
+		it.Description = *in.Description
+	}
+	if in.Price != nil {
+		it.Price = *in.Price
+	}
+	if in.Quantity != nil {
+		it.Quantity = *in.Quantity
+	}
+	if in.Reorder != nil {
+		it.Reorder = *in.Reorder
+	}
+	if in.Unit != nil {
+		it.Unit = *in.Unit
+	}
+	if in.Category != nil {
+		it.Category = *in.Category
+	}
+	if in.Tags != nil {
+		it.Tags = *in.Tags
+	}
+}
+
+// apiError is the JSON body of every error response
+type apiError struct {
+	Status  int    `json:"status"`
+	Message string `json:"error"`
+}
+
+// List items, sorted by name unless asked otherwise: GET /items
+func (s *server) items(w http.ResponseWriter, req *http.Request) {
+	lq, err := parseListQuery(req.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	list, next, err := s.query(lq)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "could not read database: %v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Items      []item `json:"items"`
+		NextCursor string `json:"next_cursor,omitempty"`
+	}{list, next})
+}
+
+// Create an item that does not exist yet: POST /items
+func (s *server) createItem(w http.ResponseWriter, req *http.Request) {
+	var in struct {
+		Name string `json:"name"`
+		itemInput
+	}
+	if err := decodeJSON(w, req, &in); err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	name := canonicalName(in.Name)
+	if name == "" {
+		writeError(w, http.StatusUnprocessableEntity, "name not set")
+		return
+	}
+	it, _, err := s.save(actorOf(req), name, func(it *item, created bool) error {
+		if !created {
+			return errItemExists
+		}
+		if in.Price == nil {
+			return invalidf("price not set")
+		}
+		in.apply(it)
+		return nil
+	})
+	if err == errItemExists {
+		writeError(w, http.StatusConflict, "item already exists: %q", name)
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("Location", "/items/"+url.PathEscape(name))
+	w.Header().Set("ETag", etag(it))
+	writeJSON(w, http.StatusCreated, it)
+}
+
+// Read a single item: GET /items/{name}
+func (s *server) getItem(w http.ResponseWriter, req *http.Request) {
+	name := itemName(req)
+	it, ok, err := s.lookup(name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "could not read database: %v", err)
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "no such item: %q", name)
+		return
+	}
+	w.Header().Set("ETag", etag(it))
+	if notModified(req, it) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, http.StatusOK, it)
+}
+
+// Create or update a single item: PUT /items/{name}
+func (s *server) putItem(w http.ResponseWriter, req *http.Request) {
+	name := itemName(req)
+	var in itemInput
+	if err := decodeJSON(w, req, &in); err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	it, created, err := s.save(actorOf(req), name, func(it *item, created bool) error {
+		if err := checkPreconditions(req, *it, !created); err != nil {
+			return err
+		}
+		if created && in.Price == nil {
+			return invalidf("price not set")
+		}
+		in.apply(it)
+		return nil
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	w.Header().Set("ETag", etag(it))
+	writeJSON(w, status, it)
+}
+
+// Delete a single item: DELETE /items/{name}
+func (s *server) deleteItem(w http.ResponseWriter, req *http.Request) {
+	name := itemName(req)
+	err := s.remove(actorOf(req), name, func(it item) error {
+		return checkPreconditions(req, it, true)
+	})
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errNoSuchItem:
+		if req.Header.Get("If-Match") != "" {
+			writeError(w, http.StatusPreconditionFailed, "%v", errPrecondition)
+			return
+		}
+		writeError(w, http.StatusNotFound, "no such item: %q", name)
+	case errPrecondition:
+		writeError(w, http.StatusPreconditionFailed, "%v", err)
+	default:
+		writeError(w, http.StatusInternalServerError, "deletion unsuccessful: %v", err)
+	}
+}
+
+// itemName returns the {name} of an /items/{name} route in canonical form
+// (see name.go)
+func itemName(req *http.Request) string {
+	return canonicalName(req.PathValue("name"))
+}
+
+// decodeJSON reads a single JSON value from the request body into v
+func decodeJSON(w http.ResponseWriter, req *http.Request, v interface{}) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid JSON body: %v", err)
+	}
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		return fmt.Errorf("invalid JSON body: unexpected data after value")
+	}
+	return nil
+}
+
+// writeJSON encodes v as the response body with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("writing JSON response: %v", err)
+	}
+}
+
+// writeError sends a structured JSON error
+func writeError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJSON(w, status, apiError{Status: status, Message: fmt.Sprintf(format, args...)})
+}
+
+// writeStoreError sends 422 for validation failures, 412 for failed
+// preconditions and 500 otherwise
+func writeStoreError(w http.ResponseWriter, err error) {
+	if _, ok := err.(invalidError); ok {
+		writeError(w, http.StatusUnprocessableEntity, "%v", err)
+		return
+	}
+	if err == errPrecondition {
+		writeError(w, http.StatusPreconditionFailed, "%v", err)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, "data store unsuccessful: %v", err)
+}
+
+// methodNotAllowed sends 405 along with the methods the resource supports
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
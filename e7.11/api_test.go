@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+)
+
+// TestItemsAPI runs an item through its life on the JSON API and checks
+// the status and body of each answer
+func TestItemsAPI(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	mux := s.handler()
+
+	for _, c := range []struct {
+		method, target, body string
+		code                 int
+		want                 string // regular expression
+	}{
+		{"GET", "/items", "", http.StatusOK, `^\{"items":\[\]\}`},
+		{"PUT", "/items/Hats", `{"price": 15}`, http.StatusCreated, `"name":"hats",.*"price":15\.00`},
+		{"PUT", "/items/hats", `{"price": 12.5}`, http.StatusOK, `"name":"hats",.*"price":12\.50`},
+		{"PUT", "/items/socks", `{"price": 2}`, http.StatusCreated, `"name":"socks",.*"price":2\.00`},
+		{"GET", "/items/hats", "", http.StatusOK, `"name":"hats",.*"price":12\.50`},
+		{"GET", "/items", "", http.StatusOK, `"name":"hats",.*"name":"socks"`},
+		{"GET", "/items/shirts", "", http.StatusNotFound, `^\{"status":404,"error":"no such item: \\"shirts\\""\}`},
+		{"PUT", "/items/hats", `{"price": -1}`, http.StatusUnprocessableEntity, `"status":422`},
+		{"PUT", "/items/shirts", `{}`, http.StatusUnprocessableEntity, `^\{"status":422,"error":"price not set"\}`},
+		{"PUT", "/items/hats", `{}`, http.StatusOK, `"price":12\.50`},
+		{"PUT", "/items/hats", `{"price": 1e3}`, http.StatusBadRequest, `"status":400`},
+		{"PUT", "/items/hats", `{"price": 19.999}`, http.StatusBadRequest, `"status":400`},
+		{"PUT", "/items/hats", `{"price": true}`, http.StatusBadRequest, `"status":400`},
+		{"PUT", "/items/hats", `{"price": 15, "colour": "red"}`, http.StatusBadRequest, `unknown field`},
+		{"PUT", "/items/hats", `{"price": 15} {}`, http.StatusBadRequest, `unexpected data after value`},
+		{"PATCH", "/items/hats", "", http.StatusMethodNotAllowed, `"status":405`},
+		{"POST", "/items", `{"name": "Hats", "price": 1}`, http.StatusConflict, `item already exists: \\"hats\\"`},
+		{"GET", "/items/a/b", "", http.StatusNotFound, `"status":404`},
+		{"DELETE", "/items/hats", "", http.StatusNoContent, `^$`},
+		{"DELETE", "/items/hats", "", http.StatusNotFound, `"status":404`},
+		{"GET", "/items", "", http.StatusOK, `^\{"items":\[\{"name":"socks",[^]]*\}\]\}`},
+	} {
+		code, body := send(mux, key, c.method, c.target, c.body)
+		if ok, _ := regexp.MatchString(c.want, body); code != c.code || !ok {
+			t.Errorf("%s %s %s: %d %s; want %d %s", c.method, c.target, c.body, code, body, c.code, c.want)
+		}
+	}
+
+	// the text endpoints see the same inventory
+	if code, body := send(mux, key, "GET", "/price?item=socks", ""); code != http.StatusOK || body != "$2.00\n" {
+		t.Errorf("price of socks: %d %q", code, body)
+	}
+}
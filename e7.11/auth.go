@@ -0,0 +1,326 @@
+/* API key authentication and role-based access.
+Clients send "Authorization: Bearer <key>" (or "X-API-Key: <key>").
+A key is "<id>.<secret>"; only the SHA-256 hash of the secret is stored.
+Roles: reader (GET), editor (reader + mutations), admin (editor + key admin).
+The dashboard (see dashboard.go) checks a key once at sign-in and then
+uses a session cookie; the key's role still applies.
+POST   /admin/keys       issue a key ({"name": "till-1", "role": "editor"})
+GET    /admin/keys       list keys (without secrets)
+DELETE /admin/keys/{id}  revoke a key
+If no active admin key exists at startup, one is issued and logged once. */
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// apiKeysBucket holds key id -> apiKey records
+var apiKeysBucket = []byte("apikeys")
+
+// Roles, in increasing order of privilege
+const (
+	roleReader = "reader"
+	roleEditor = "editor"
+	roleAdmin  = "admin"
+)
+
+// roleRank orders the roles; unknown roles rank 0 and grant nothing
+var roleRank = map[string]int{roleReader: 1, roleEditor: 2, roleAdmin: 3}
+
+// maxKeyNameLen bounds the name given to a key
+const maxKeyNameLen = 64
+
+// apiKey is the stored record of an issued key
+type apiKey struct {
+	ID      string     `json:"id"`
+	Name    string     `json:"name"`
+	Role    string     `json:"role"`
+	Hash    string     `json:"hash,omitempty"` // hex SHA-256 of the secret
+	Created time.Time  `json:"created"`
+	Revoked *time.Time `json:"revoked,omitempty"`
+}
+
+// label names k in the history
+func (k apiKey) label() string {
+	return k.Name + "#" + k.ID
+}
+
+// errLastAdmin rejects revoking the only remaining admin key
+var errLastAdmin = errors.New("cannot revoke the last active admin key")
+
+// ctxKey keys values stored in request contexts
+type ctxKey int
+
+// apiKeyCtx holds the authenticated apiKey of a request
+const apiKeyCtx ctxKey = 0
+
+// keyOf returns the key that authenticated req, if any
+func keyOf(req *http.Request) (apiKey, bool) {
+	k, ok := req.Context().Value(apiKeyCtx).(apiKey)
+	return k, ok
+}
+
+// require wraps h so that it only runs for requests carrying an active key
+// with at least role
+func (s *server) require(role string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		k, err := s.authenticate(req)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="inventory"`)
+			writeError(w, http.StatusUnauthorized, "%v", err)
+			return
+		}
+		if roleRank[k.Role] < roleRank[role] {
+			writeError(w, http.StatusForbidden, "%s key cannot access this endpoint; %s role required", k.Role, role)
+			return
+		}
+		h(w, req.WithContext(context.WithValue(req.Context(), apiKeyCtx, k)))
+	}
+}
+
+// authenticate looks up the key presented with req
+func (s *server) authenticate(req *http.Request) (apiKey, error) {
+	presented := req.Header.Get("X-API-Key")
+	if auth := req.Header.Get("Authorization"); presented == "" && auth != "" {
+		const prefix = "Bearer "
+		if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+			return apiKey{}, errors.New("authorization must use the Bearer scheme")
+		}
+		presented = strings.TrimSpace(auth[len(prefix):])
+	}
+	if presented == "" {
+		return apiKey{}, errors.New("API key required")
+	}
+	return s.verifyKey(presented)
+}
+
+// errInvalidKey rejects keys that are unknown, revoked or malformed
+var errInvalidKey = errors.New("invalid or revoked API key")
+
+// verifyKey checks a presented "<id>.<secret>" key against its record
+func (s *server) verifyKey(presented string) (apiKey, error) {
+	i := strings.IndexByte(presented, '.')
+	if i < 0 {
+		return apiKey{}, errInvalidKey
+	}
+	id, secret := presented[:i], presented[i+1:]
+	k, found, err := s.loadKey(id)
+	if err != nil {
+		return apiKey{}, err
+	}
+	sum := sha256.Sum256([]byte(secret))
+	if !found || k.Revoked != nil || subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(k.Hash)) != 1 {
+		return apiKey{}, errInvalidKey
+	}
+	return k, nil
+}
+
+// loadKey reads the record of key id
+func (s *server) loadKey(id string) (k apiKey, found bool, err error) {
+	err = s.store.Tx(false, func(tx StoreTx) error {
+		v := tx.Bucket(apiKeysBucket).Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(v, &k)
+	})
+	return k, found, err
+}
+
+// issueKey creates a key for name with role and returns its record and
+// the full key string, which is not stored and cannot be recovered
+func (s *server) issueKey(name, role string) (k apiKey, secret string, err error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxKeyNameLen {
+		return k, "", invalidf("name must be between 1 and %d characters", maxKeyNameLen)
+	}
+	if roleRank[role] == 0 {
+		return k, "", invalidf("role must be %q, %q or %q", roleReader, roleEditor, roleAdmin)
+	}
+	id, err := randomString(8, hex.EncodeToString)
+	if err != nil {
+		return k, "", err
+	}
+	sec, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return k, "", err
+	}
+	sum := sha256.Sum256([]byte(sec))
+	k = apiKey{ID: id, Name: name, Role: role, Hash: hex.EncodeToString(sum[:]), Created: time.Now().UTC()}
+
+	err = s.store.Tx(true, func(tx StoreTx) error {
+		v, err := json.Marshal(k)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(apiKeysBucket).Put([]byte(id), v)
+	})
+	if err == nil {
+		s.feed.notify() // followers copy the keys with the change log
+	}
+	return k, id + "." + sec, err
+}
+
+// revokeKey marks key id as revoked
+func (s *server) revokeKey(id string) (k apiKey, err error) {
+	err = s.store.Tx(true, func(tx StoreTx) error {
+		keys, err := listKeys(tx)
+		if err != nil {
+			return err
+		}
+		admins, found := 0, false
+		for _, key := range keys {
+			if key.Revoked == nil && key.Role == roleAdmin {
+				admins++
+			}
+			if key.ID == id {
+				k, found = key, true
+			}
+		}
+		if !found {
+			return errNoSuchKey
+		}
+		if k.Revoked != nil {
+			return nil // already revoked
+		}
+		if k.Role == roleAdmin && admins == 1 {
+			return errLastAdmin
+		}
+		now := time.Now().UTC()
+		k.Revoked = &now
+		v, err := json.Marshal(k)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(apiKeysBucket).Put([]byte(id), v)
+	})
+	if err == nil {
+		s.feed.notify()
+	}
+	return k, err
+}
+
+// errNoSuchKey is returned when revoking an unknown key
+var errNoSuchKey = errors.New("no such key")
+
+// listKeys reads every key record in tx, sorted by id
+func listKeys(tx StoreTx) ([]apiKey, error) {
+	keys := []apiKey{}
+	err := tx.Bucket(apiKeysBucket).ForEach(func(k, v []byte) error {
+		var key apiKey
+		if err := json.Unmarshal(v, &key); err != nil {
+			return fmt.Errorf("key %q: %v", k, err)
+		}
+		keys = append(keys, key)
+		return nil
+	})
+	return keys, err
+}
+
+// keysDigest summarizes keys, so that two servers can tell whether they
+// hold the same records without exchanging them
+func keysDigest(keys []apiKey) (string, error) {
+	v, err := json.Marshal(keys)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(v)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// bootstrapAdminKey issues an admin key if there is no active one, so a
+// fresh database can be administered. It returns the new key or "".
+func (s *server) bootstrapAdminKey() (string, error) {
+	var active bool
+	if err := s.store.Tx(false, func(tx StoreTx) error {
+		keys, err := listKeys(tx)
+		for _, k := range keys {
+			active = active || k.Revoked == nil && k.Role == roleAdmin
+		}
+		return err
+	}); err != nil || active {
+		return "", err
+	}
+	_, key, err := s.issueKey("bootstrap", roleAdmin)
+	return key, err
+}
+
+// randomString returns n random bytes encoded with enc
+func randomString(n int, enc func([]byte) string) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return enc(b), nil
+}
+
+// List keys, without their hashes: GET /admin/keys
+func (s *server) adminKeys(w http.ResponseWriter, req *http.Request) {
+	var keys []apiKey
+	if err := s.store.Tx(false, func(tx StoreTx) (err error) {
+		keys, err = listKeys(tx)
+		return err
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, "could not read keys: %v", err)
+		return
+	}
+	for i := range keys {
+		keys[i].Hash = ""
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Keys []apiKey `json:"keys"`
+	}{keys})
+}
+
+// Issue a key: POST /admin/keys
+func (s *server) adminIssueKey(w http.ResponseWriter, req *http.Request) {
+	var in struct {
+		Name string `json:"name"`
+		Role string `json:"role"`
+	}
+	if err := decodeJSON(w, req, &in); err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	k, key, err := s.issueKey(in.Name, in.Role)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	k.Hash = ""
+	writeJSON(w, http.StatusCreated, struct {
+		apiKey
+		Key string `json:"key"` // shown once
+	}{k, key})
+}
+
+// Revoke a key: DELETE /admin/keys/{id}
+func (s *server) adminRevokeKey(w http.ResponseWriter, req *http.Request) {
+	id := req.PathValue("id")
+	k, err := s.revokeKey(id)
+	switch err {
+	case nil:
+		k.Hash = ""
+		writeJSON(w, http.StatusOK, k)
+	case errNoSuchKey:
+		writeError(w, http.StatusNotFound, "no such key: %q", id)
+	case errLastAdmin:
+		writeError(w, http.StatusConflict, "%v", err)
+	default:
+		writeError(w, http.StatusInternalServerError, "could not revoke key: %v", err)
+	}
+}
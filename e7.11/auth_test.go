@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestRequire checks how keys are presented and which roles reach which
+// endpoints
+func TestRequire(t *testing.T) {
+	s, admin := newTestServer(t, newMemStore())
+	h := s.handler()
+	issue := func(role string) string {
+		_, key, err := s.issueKey(role+" key", role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	reader, editor, revoked := issue(roleReader), issue(roleEditor), issue(roleReader)
+	if _, err := s.revokeKey(revoked[:strings.IndexByte(revoked, '.')]); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name, key, method, path string
+		header                  []string
+		code                    int
+		want                    string
+	}{
+		{"no key", "", "GET", "/items", nil, http.StatusUnauthorized, "API key required"},
+		{"basic scheme", "", "GET", "/items", []string{"Authorization", "Basic " + reader}, http.StatusUnauthorized, "Bearer scheme"},
+		{"short header", "", "GET", "/items", []string{"Authorization", "Bear"}, http.StatusUnauthorized, "Bearer scheme"},
+		{"no secret", "", "GET", "/items", []string{"Authorization", "Bearer abc"}, http.StatusUnauthorized, "invalid or revoked"},
+		{"wrong secret", reader[:strings.IndexByte(reader, '.')] + ".nope", "GET", "/items", nil, http.StatusUnauthorized, "invalid or revoked"},
+		{"revoked", revoked, "GET", "/items", nil, http.StatusUnauthorized, "invalid or revoked"},
+		{"reader reads", reader, "GET", "/items", nil, http.StatusOK, `"items"`},
+		{"lower-case scheme", "", "GET", "/items", []string{"Authorization", "bearer " + reader}, http.StatusOK, `"items"`},
+		{"X-API-Key", "", "GET", "/items", []string{"Authorization", "", "X-API-Key", reader}, http.StatusOK, `"items"`},
+		{"reader edits", reader, "PUT", "/items/hats", nil, http.StatusForbidden, "editor role required"},
+		{"editor edits", editor, "PUT", "/items/hats", nil, http.StatusCreated, `"name":"hats"`},
+		{"editor administers", editor, "GET", "/admin/keys", nil, http.StatusForbidden, "admin role required"},
+		{"admin administers", admin, "GET", "/admin/keys", nil, http.StatusOK, `"role":"reader"`},
+	} {
+		body := ""
+		if c.method == "PUT" {
+			body = `{"price": 1}`
+		}
+		if code, resp := send(h, c.key, c.method, c.path, body, c.header...); code != c.code || !strings.Contains(resp, c.want) {
+			t.Errorf("%s: %s %s: %d %s; want %d containing %q", c.name, c.method, c.path, code, resp, c.code, c.want)
+		}
+	}
+	if code, resp := send(h, admin, "GET", "/admin/keys", ""); strings.Contains(resp, `"hash"`) {
+		t.Errorf("key listing shows hashes: %d %s", code, resp)
+	}
+}
+
+// TestRevokeKeys checks that the last admin key cannot be revoked, and that
+// a fresh database gets exactly one admin key
+func TestRevokeKeys(t *testing.T) {
+	s, admin := newTestServer(t, newMemStore())
+	h := s.handler()
+	adminID := admin[:strings.IndexByte(admin, '.')]
+
+	if key, err := s.bootstrapAdminKey(); err != nil || key != "" {
+		t.Errorf("bootstrapAdminKey with an admin key: %q, %v; want none", key, err)
+	}
+	for _, c := range []struct {
+		path string
+		code int
+		want string
+	}{
+		{"/admin/keys/" + adminID, http.StatusConflict, "last active admin key"},
+		{"/admin/keys/nope", http.StatusNotFound, "no such key"},
+	} {
+		if code, resp := send(h, admin, "DELETE", c.path, ""); code != c.code || !strings.Contains(resp, c.want) {
+			t.Errorf("DELETE %s: %d %s; want %d containing %q", c.path, code, resp, c.code, c.want)
+		}
+	}
+
+	code, resp := send(h, admin, "POST", "/admin/keys", `{"name": "second", "role": "admin"}`)
+	if code != http.StatusCreated || !strings.Contains(resp, `"key":"`) {
+		t.Fatalf("issue: %d %s", code, resp)
+	}
+	second := resp[strings.Index(resp, `"key":"`)+7:]
+	second = second[:strings.IndexByte(second, '"')]
+	if code, resp := send(h, second, "DELETE", "/admin/keys/"+adminID, ""); code != http.StatusOK || !strings.Contains(resp, `"revoked"`) {
+		t.Errorf("revoke first admin: %d %s", code, resp)
+	}
+	if code, _ := send(h, admin, "GET", "/items", ""); code != http.StatusUnauthorized {
+		t.Errorf("revoked admin key: %d; want %d", code, http.StatusUnauthorized)
+	}
+	if code, resp := send(h, second, "POST", "/admin/keys", `{"name": "", "role": "reader"}`); code != http.StatusUnprocessableEntity {
+		t.Errorf("issue without a name: %d %s; want %d", code, resp, http.StatusUnprocessableEntity)
+	}
+	if key, err := s.bootstrapAdminKey(); err != nil || key != "" {
+		t.Errorf("bootstrapAdminKey with a second admin key: %q, %v; want none", key, err)
+	}
+}
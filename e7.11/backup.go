@@ -0,0 +1,117 @@
+/* Online backup, restore and compaction of the database file.
+GET  /admin/backup   stream a consistent snapshot of the database
+POST /admin/restore  replace the database with an uploaded snapshot
+POST /admin/compact  rewrite the database file without its free pages
+Ex ("curl -H 'Authorization: Bearer <key>' -o inventory.db http://localhost:8000/admin/backup")
+Ex ("curl -H 'Authorization: Bearer <key>' --data-binary @inventory.db http://localhost:8000/admin/restore")
+A restored snapshot brings its own API keys: keys issued after the backup
+was taken stop working. Only stores backed by a file support these. */
+
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxRestoreBytes limits the size of an uploaded snapshot
+const maxRestoreBytes = 1 << 30
+
+// snapshotter is implemented by stores whose contents live in a single
+// file that can be copied and replaced while the server runs
+type snapshotter interface {
+	// Backup writes a consistent snapshot to w, calling size with its
+	// length first
+	Backup(w io.Writer, size func(n int64)) (int64, error)
+	// Restore replaces the contents of the store with the snapshot read
+	// from r, after running prepare on it
+	Restore(r io.Reader, prepare func(InventoryStore) error) error
+	// Compact reclaims unused space, returning the sizes before and after
+	Compact() (before, after int64, err error)
+}
+
+// snapshots returns the store as a snapshotter, or sends 501 if it is not
+// one
+func (s *server) snapshots(w http.ResponseWriter) (snapshotter, bool) {
+	ss, ok := s.store.(snapshotter)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "the inventory store does not support snapshots")
+	}
+	return ss, ok
+}
+
+// Download a snapshot: GET /admin/backup
+func (s *server) backup(w http.ResponseWriter, req *http.Request) {
+	ss, ok := s.snapshots(w)
+	if !ok {
+		return
+	}
+	name := "inventory-" + time.Now().UTC().Format("20060102T150405Z") + ".db"
+	started := false
+	n, err := ss.Backup(w, func(n int64) {
+		started = true
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatInt(n, 10))
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	})
+	switch {
+	case err == nil:
+		log.Printf("backup: %d bytes sent to %s", n, actorOf(req))
+	case !started:
+		writeError(w, http.StatusInternalServerError, "backup unsuccessful: %v", err)
+	default:
+		// the status has gone; the short body tells the client
+		log.Printf("backup: %v after %d bytes", err, n)
+	}
+}
+
+// Upload a snapshot: POST /admin/restore
+func (s *server) restore(w http.ResponseWriter, req *http.Request) {
+	ss, ok := s.snapshots(w)
+	if !ok {
+		return
+	}
+	body := http.MaxBytesReader(w, req.Body, maxRestoreBytes)
+	// a new generation tells followers the log they tail has been replaced
+	prepare := func(st InventoryStore) error {
+		if err := prepareStore(st); err != nil {
+			return err
+		}
+		return st.Tx(true, func(tx StoreTx) error { return setGeneration(tx, true) })
+	}
+	if err := ss.Restore(body, prepare); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	log.Printf("restore: database replaced by %s", actorOf(req))
+	if err := s.names.load(s.store); err != nil {
+		log.Printf("restore: could not rebuild search index: %v", err)
+	}
+	// the history and schedules are now those of the snapshot
+	s.feed.notify()
+	s.wakeScheduler()
+	writeJSON(w, http.StatusOK, struct {
+		Restored time.Time `json:"restored"`
+	}{time.Now().UTC()})
+}
+
+// Reclaim free pages: POST /admin/compact
+func (s *server) compact(w http.ResponseWriter, req *http.Request) {
+	ss, ok := s.snapshots(w)
+	if !ok {
+		return
+	}
+	before, after, err := ss.Compact()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	log.Printf("compact: %d -> %d bytes", before, after)
+	writeJSON(w, http.StatusOK, struct {
+		Before int64 `json:"size_before"`
+		After  int64 `json:"size_after"`
+	}{before, after})
+}
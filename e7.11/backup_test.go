@@ -0,0 +1,132 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBackupRestore takes a snapshot, changes and compacts the database and
+// restores the snapshot
+func TestBackupRestore(t *testing.T) {
+	s, key := newTestServer(t, openTestBolt(t))
+	h := s.handler()
+	send(h, key, "PUT", "/items/hats", `{"price": 1}`)
+	code, snapshot := send(h, key, "GET", "/admin/backup", "")
+	if code != http.StatusOK || len(snapshot) == 0 {
+		t.Fatalf("backup: %d, %d bytes", code, len(snapshot))
+	}
+	for i := 0; i < 50; i++ {
+		send(h, key, "PUT", "/items/socks"+strings.Repeat("s", i), `{"price": 2}`)
+	}
+	for i := 0; i < 50; i++ {
+		send(h, key, "DELETE", "/items/socks"+strings.Repeat("s", i), "")
+	}
+	if code, body := send(h, key, "POST", "/admin/compact", ""); code != http.StatusOK || !strings.Contains(body, `"size_after"`) {
+		t.Fatalf("compact: %d %s", code, body)
+	}
+	send(h, key, "PUT", "/items/shirts", `{"price": 3}`)
+
+	if code, body := send(h, key, "POST", "/admin/restore", "not a database"); code != http.StatusUnprocessableEntity {
+		t.Errorf("restore of garbage: %d %s; want %d", code, body, http.StatusUnprocessableEntity)
+	}
+	if code, body := send(h, key, "GET", "/list", ""); body != "hats: $1.00\nshirts: $3.00\n" {
+		t.Errorf("after the refused restore: %d %q", code, body)
+	}
+	if code, body := send(h, key, "POST", "/admin/restore", snapshot); code != http.StatusOK {
+		t.Fatalf("restore: %d %s", code, body)
+	}
+	if code, body := send(h, key, "GET", "/list", ""); body != "hats: $1.00\n" {
+		t.Errorf("after restoring: %d %q", code, body)
+	}
+}
+
+// TestSnapshotsUnsupported checks that a store without a file answers 501
+func TestSnapshotsUnsupported(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	h := s.handler()
+	for _, c := range []struct{ method, path string }{
+		{"GET", "/admin/backup"}, {"POST", "/admin/restore"}, {"POST", "/admin/compact"},
+	} {
+		if code, body := send(h, key, c.method, c.path, ""); code != http.StatusNotImplemented {
+			t.Errorf("%s %s: %d %s; want %d", c.method, c.path, code, body, http.StatusNotImplemented)
+		}
+	}
+}
+
+// TestBackupReleasesStore checks that a backup whose client has stopped
+// reading does not hold up compaction or the transactions behind it
+func TestBackupReleasesStore(t *testing.T) {
+	st := openTestBolt(t)
+	if err := st.Put(item{Name: "hats", Price: 100, Unit: defaultUnit}); err != nil {
+		t.Fatal(err)
+	}
+	pr, pw := io.Pipe()
+	snapped := make(chan int64, 1)
+	done := make(chan error, 1)
+	go func() {
+		_, err := st.Backup(pw, func(n int64) { snapped <- n })
+		pw.CloseWithError(err)
+		done <- err
+	}()
+	n := <-snapped // nothing has been read from the pipe yet
+
+	finished := make(chan error, 1)
+	go func() {
+		_, _, err := st.Compact()
+		if err == nil {
+			_, _, err = st.Get("hats")
+		}
+		finished <- err
+	}()
+	select {
+	case err := <-finished:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("compaction waited for the backup download")
+	}
+
+	got, err := io.ReadAll(pr)
+	if err != nil || int64(len(got)) != n {
+		t.Fatalf("backup read %d of %d bytes: %v", len(got), n, err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestRestoreResetsEvents checks that an event stream whose position is
+// past the end of a restored history is told so and then carries on
+func TestRestoreResetsEvents(t *testing.T) {
+	s, key := newTestServer(t, openTestBolt(t))
+	h := s.handler()
+	send(h, key, "PUT", "/items/hats", `{"price": 1}`)
+	code, snapshot := send(h, key, "GET", "/admin/backup", "")
+	if code != http.StatusOK {
+		t.Fatalf("backup: %d %s", code, snapshot)
+	}
+	send(h, key, "PUT", "/items/socks", `{"price": 2}`)
+	send(h, key, "PUT", "/items/shirts", `{"price": 3}`) // seq 3
+
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+	defer s.feed.stop() // end the stream before the server closes
+	expect := streamEvents(t, ts, key, "/events", "Last-Event-ID", "3")
+	expect("retry: 2000")
+
+	if code, body := send(h, key, "POST", "/admin/restore", snapshot); code != http.StatusOK {
+		t.Fatalf("restore: %d %s", code, body)
+	}
+	expect("id: 1", "event: reset", `data: {"seq":1}`)
+	if len(s.schedWake) == 0 {
+		t.Error("restore did not wake the scheduler")
+	}
+
+	send(h, key, "PUT", "/items/socks", `{"price": 4}`)
+	expect("id: 2", "event: create")
+}
@@ -0,0 +1,163 @@
+/* Batch changes to the inventory.
+POST /batch  apply several upserts and deletes in one transaction
+	{"ops": [{"op": "upsert", "item": "hats", "price": 12.5},
+	         {"op": "delete", "item": "socks"}]}
+Upserts take the fields of PUT /items/{name}. Operations run in order and
+each gets a result; if any of them fails, none is applied and the response
+is 422 with the results showing which operations failed. */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Batch operations
+const (
+	batchUpsert = "upsert"
+	batchDelete = "delete"
+)
+
+// maxBatchOps bounds the number of operations in one batch
+const maxBatchOps = 1000
+
+// batchOp is one operation of a batch
+type batchOp struct {
+	Op   string `json:"op"`
+	Item string `json:"item"`
+	itemInput
+}
+
+// batchResult reports the outcome of one batchOp; Status is the code the
+// equivalent /items/{name} request would have returned
+type batchResult struct {
+	Op     string `json:"op"`
+	Item   string `json:"item"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Record *item  `json:"record,omitempty"` // stored item after an upsert
+}
+
+// errBatchFailed rolls back a batch in which some operation failed
+var errBatchFailed = errors.New("batch rolled back")
+
+// Apply a batch of changes: POST /batch
+func (s *server) batch(w http.ResponseWriter, req *http.Request) {
+	var in struct {
+		Ops []batchOp `json:"ops"`
+	}
+	if err := decodeJSON(w, req, &in); err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	if len(in.Ops) == 0 || len(in.Ops) > maxBatchOps {
+		writeError(w, http.StatusUnprocessableEntity, "batch must have between 1 and %d operations", maxBatchOps)
+		return
+	}
+
+	results, err := s.applyBatch(actorOf(req), in.Ops)
+	switch err {
+	case nil:
+		writeJSON(w, http.StatusOK, struct {
+			Results []batchResult `json:"results"`
+		}{results})
+	case errBatchFailed:
+		failed := 0
+		for _, r := range results {
+			if r.Error != "" {
+				failed++
+			}
+		}
+		writeJSON(w, http.StatusUnprocessableEntity, struct {
+			apiError
+			Results []batchResult `json:"results"`
+		}{apiError{http.StatusUnprocessableEntity, fmt.Sprintf("%v: %d of %d operations failed", err, failed, len(results))}, results})
+	default:
+		writeError(w, http.StatusInternalServerError, "data store unsuccessful: %v", err)
+	}
+}
+
+// applyBatch runs ops in one transaction on behalf of actor. Every
+// operation is attempted so that all failures are reported; if any failed,
+// the transaction is rolled back and errBatchFailed returned.
+func (s *server) applyBatch(actor string, ops []batchOp) (results []batchResult, err error) {
+	err = s.write(actor, func(w *writeTx) error {
+		results = make([]batchResult, len(ops))
+		failed := false
+		for i, op := range ops {
+			r, err := w.applyOp(op)
+			if err != nil {
+				return err // not the operation's fault: abort
+			}
+			failed = failed || r.Error != ""
+			results[i] = r
+		}
+		if failed {
+			for i := range results {
+				results[i].Record = nil // never stored
+			}
+			return errBatchFailed
+		}
+		return nil
+	})
+	return results, err
+}
+
+// applyOp applies op within w. Rejected operations are reported in the
+// result; only store failures are returned as errors.
+func (w *writeTx) applyOp(op batchOp) (r batchResult, err error) {
+	r = batchResult{Op: op.Op, Item: canonicalName(op.Item)}
+	fail := func(status int, err error) (batchResult, error) {
+		r.Status, r.Error = status, err.Error()
+		return r, nil
+	}
+	if err := checkName(r.Item); err != nil {
+		return fail(http.StatusUnprocessableEntity, err)
+	}
+
+	switch op.Op {
+	case batchUpsert:
+		it, ok, err := w.get(r.Item)
+		if err != nil {
+			return r, err
+		}
+		if !ok {
+			if op.Price == nil {
+				return fail(http.StatusUnprocessableEntity, invalidf("price not set"))
+			}
+			it = item{Name: r.Item, Unit: defaultUnit}
+		}
+		op.apply(&it)
+		if err := w.put(it); err != nil {
+			if _, invalid := err.(invalidError); invalid {
+				return fail(http.StatusUnprocessableEntity, err)
+			}
+			return r, err
+		}
+		if it, _, err = w.get(r.Item); err != nil {
+			return r, err
+		}
+		r.Status, r.Record = http.StatusOK, &it
+		if !ok {
+			r.Status = http.StatusCreated
+		}
+
+	case batchDelete:
+		if op.itemInput != (itemInput{}) {
+			return fail(http.StatusUnprocessableEntity, invalidf("delete takes no item fields"))
+		}
+		if err := w.delete(r.Item); err != nil {
+			if err == errNoSuchItem {
+				return fail(http.StatusNotFound, fmt.Errorf("no such item: %q", r.Item))
+			}
+			return r, err
+		}
+		r.Status = http.StatusNoContent
+
+	default:
+		return fail(http.StatusUnprocessableEntity, invalidf("op must be %q or %q", batchUpsert, batchDelete))
+	}
+	return r, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestBatch checks that a batch is applied whole or not at all, with a
+// result for every operation
+func TestBatch(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	h := s.handler()
+	for _, c := range []struct {
+		body    string
+		code    int
+		results string // status of each operation
+	}{
+		{`{"ops": [{"op": "upsert", "item": "Hats", "price": 1}, {"op": "upsert", "item": "socks", "price": 2, "quantity": 4}]}`, http.StatusOK, "201 201"},
+		{`{"ops": [{"op": "upsert", "item": "hats", "price": 9}, {"op": "delete", "item": "gloves"}, {"op": "upsert", "item": "scarf"}]}`, http.StatusUnprocessableEntity, "200 404 422"},
+		{`{"ops": [{"op": "delete", "item": "socks", "price": 1}, {"op": "move", "item": "hats"}, {"op": "upsert", "item": "a/b", "price": 1}]}`, http.StatusUnprocessableEntity, "422 422 422"},
+		{`{"ops": [{"op": "upsert", "item": "hats", "price": 9}, {"op": "delete", "item": "socks"}]}`, http.StatusOK, "200 204"},
+		{`{"ops": []}`, http.StatusUnprocessableEntity, ""},
+		{`{"ops": [{"op": "upsert", "item": "hats", "colour": "red"}]}`, http.StatusBadRequest, ""},
+	} {
+		code, body := send(h, key, "POST", "/batch", c.body)
+		var resp struct{ Results []batchResult }
+		if err := json.Unmarshal([]byte(body), &resp); code != c.code || err != nil {
+			t.Errorf("%s: %d %s; want %d", c.body, code, body, c.code)
+			continue
+		}
+		var got []string
+		for _, r := range resp.Results {
+			got = append(got, fmt.Sprint(r.Status))
+			if code != http.StatusOK && r.Record != nil {
+				t.Errorf("%s: a rolled back operation returned a record: %+v", c.body, r)
+			}
+		}
+		if strings.Join(got, " ") != c.results {
+			t.Errorf("%s: results %s; want %s", c.body, strings.Join(got, " "), c.results)
+		}
+	}
+
+	// only the first and fourth batches were applied
+	if code, body := send(h, key, "GET", "/list", ""); body != "hats: $9.00\n" {
+		t.Errorf("after the batches: %d %q", code, body)
+	}
+	if code, _ := send(h, key, "GET", "/batch", ""); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /batch: %d; want %d", code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,281 @@
+/* Categories and tags.
+An item may have one category, a path such as "clothing/shirts", and any
+number of free-form tags. Both are kept in index buckets next to the price
+index, so lists can be scoped to them without scanning the inventory:
+	GET /list?category=clothing    items in clothing and its subcategories
+	GET /items?tag=sale            items tagged "sale"
+GET  /categories              every category with its item counts
+GET  /tags                    every tag with its item count
+POST /items/{name}/move       move an item ({"to": "clothing/shirts"}); with
+	"from" the move only happens if the item is still in that category (409)
+Categories and tags are set with PUT /items/{name} like any other field
+({"category": "clothing/shirts", "tags": ["sale", "summer"]}). Each level of
+a category, and each tag, is put in canonical form as item names are (see
+name.go), so "Straße" and "STRASSE" are one tag. */
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// categoryIndexBucket holds category + "/" + 0x00 + item name -> nil.
+// The "/" makes every key in a category's subtree start with the category
+// followed by "/", so a subtree is one contiguous range.
+var categoryIndexBucket = []byte("category_index")
+
+// tagIndexBucket holds tag + 0x00 + item name -> nil
+var tagIndexBucket = []byte("tag_index")
+
+// Limits for categories and tags
+const (
+	maxCategoryLen   = 128
+	maxCategoryDepth = 8
+	maxTags          = 20
+	maxTagLen        = 32
+)
+
+// errWrongCategory rejects a move whose "from" no longer holds the item
+var errWrongCategory = errors.New("item is no longer in the expected category")
+
+// cleanCategory returns s in canonical form: segments canonical as item
+// names are (see name.go), separated by single slashes
+func cleanCategory(s string) (string, error) {
+	s = strings.Trim(strings.TrimSpace(s), "/")
+	if s == "" {
+		return "", nil
+	}
+	parts := strings.Split(s, "/")
+	if len(parts) > maxCategoryDepth {
+		return "", invalidf("category may be at most %d levels deep", maxCategoryDepth)
+	}
+	for i, p := range parts {
+		if parts[i] = canonicalName(p); parts[i] == "" {
+			return "", invalidf("category %q has an empty level", s)
+		}
+		if strings.IndexFunc(p, unicode.IsControl) >= 0 {
+			return "", invalidf("category must not contain control characters")
+		}
+	}
+	s = strings.Join(parts, "/")
+	if utf8.RuneCountInString(s) > maxCategoryLen {
+		return "", invalidf("category must be at most %d characters", maxCategoryLen)
+	}
+	return s, nil
+}
+
+// cleanTag returns t in canonical form, as canonicalName does for names
+func cleanTag(t string) (string, error) {
+	t = canonicalName(t)
+	switch {
+	case t == "":
+		return "", invalidf("tags must not be empty")
+	case utf8.RuneCountInString(t) > maxTagLen:
+		return "", invalidf("tag %q is longer than %d characters", t, maxTagLen)
+	case strings.ContainsAny(t, ",;/") || strings.IndexFunc(t, unicode.IsControl) >= 0:
+		return "", invalidf("tag %q must not contain , ; / or control characters", t)
+	}
+	return t, nil
+}
+
+// cleanTags returns tags in canonical form, sorted and without duplicates
+func cleanTags(tags []string) ([]string, error) {
+	var clean []string
+	seen := make(map[string]bool)
+	for _, t := range tags {
+		t, err := cleanTag(t)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[t] {
+			seen[t] = true
+			clean = append(clean, t)
+		}
+	}
+	if len(clean) > maxTags {
+		return nil, invalidf("an item may have at most %d tags", maxTags)
+	}
+	sort.Strings(clean)
+	return clean, nil
+}
+
+// normalize puts the category and tags of it in canonical form
+func (it *item) normalize() (err error) {
+	if it.Category, err = cleanCategory(it.Category); err != nil {
+		return err
+	}
+	it.Tags, err = cleanTags(it.Tags)
+	return err
+}
+
+// inCategory reports whether it is in category c or one of its
+// subcategories
+func (it item) inCategory(c string) bool {
+	return c == "" || it.Category == c || strings.HasPrefix(it.Category, c+"/")
+}
+
+// hasTag reports whether it is tagged t
+func (it item) hasTag(t string) bool {
+	i := sort.SearchStrings(it.Tags, t)
+	return i < len(it.Tags) && it.Tags[i] == t
+}
+
+// categoryPrefix returns the common prefix of the category index keys of
+// c and its subcategories
+func categoryPrefix(c string) []byte {
+	return []byte(c + "/")
+}
+
+// categoryIndexKeys returns the category index key of it, if it has a
+// category
+func categoryIndexKeys(it item) [][]byte {
+	if it.Category == "" {
+		return nil
+	}
+	return [][]byte{append(append(categoryPrefix(it.Category), 0), it.Name...)}
+}
+
+// tagPrefix returns the common prefix of the tag index keys of t
+func tagPrefix(t string) []byte {
+	return append([]byte(t), 0)
+}
+
+// tagIndexKeys returns the tag index keys of it
+func tagIndexKeys(it item) [][]byte {
+	keys := make([][]byte, len(it.Tags))
+	for i, t := range it.Tags {
+		keys[i] = append(tagPrefix(t), it.Name...)
+	}
+	return keys
+}
+
+// indexedName returns the item name of a category or tag index key
+func indexedName(k []byte) string {
+	return string(k[bytes.IndexByte(k, 0)+1:])
+}
+
+// categoryCount reports the items in one category
+type categoryCount struct {
+	Category string `json:"category"`
+	Items    int    `json:"items"` // directly in the category
+	Total    int    `json:"total"` // including subcategories
+}
+
+// List categories: GET /categories
+func (s *server) categories(w http.ResponseWriter, req *http.Request) {
+	counts := make(map[string]*categoryCount)
+	count := func(c string) *categoryCount {
+		if counts[c] == nil {
+			counts[c] = &categoryCount{Category: c}
+		}
+		return counts[c]
+	}
+	err := s.store.Tx(false, func(tx StoreTx) error {
+		return tx.Bucket(categoryIndexBucket).ForEach(func(k, _ []byte) error {
+			c := string(k[:bytes.IndexByte(k, 0)-1]) // drop "/" 0x00 name
+			count(c).Items++
+			for i := len(c); i > 0; i = strings.LastIndexByte(c[:i], '/') {
+				count(c[:i]).Total++
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "could not read categories: %v", err)
+		return
+	}
+	list := []categoryCount{}
+	for _, c := range counts {
+		list = append(list, *c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Category < list[j].Category })
+	writeJSON(w, http.StatusOK, struct {
+		Categories []categoryCount `json:"categories"`
+	}{list})
+}
+
+// List tags: GET /tags
+func (s *server) tags(w http.ResponseWriter, req *http.Request) {
+	type tagCount struct {
+		Tag   string `json:"tag"`
+		Items int    `json:"items"`
+	}
+	list := []tagCount{}
+	err := s.store.Tx(false, func(tx StoreTx) error {
+		// keys are sorted by tag, so each tag's entries are adjacent
+		return tx.Bucket(tagIndexBucket).ForEach(func(k, _ []byte) error {
+			t := string(k[:bytes.IndexByte(k, 0)])
+			if n := len(list); n > 0 && list[n-1].Tag == t {
+				list[n-1].Items++
+			} else {
+				list = append(list, tagCount{t, 1})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "could not read tags: %v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Tags []tagCount `json:"tags"`
+	}{list})
+}
+
+// Move an item to another category: POST /items/{name}/move
+func (s *server) moveItem(w http.ResponseWriter, req *http.Request) {
+	name := itemName(req)
+	var in struct {
+		To   string  `json:"to"`
+		From *string `json:"from"`
+	}
+	if err := decodeJSON(w, req, &in); err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	to, err := cleanCategory(in.To)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	var from string
+	if in.From != nil {
+		if from, err = cleanCategory(*in.From); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+	}
+
+	// the check and the move happen in one transaction, and the item and
+	// its index entries are updated together
+	it, _, err := s.save(actorOf(req), name, func(it *item, created bool) error {
+		if created {
+			return errNoSuchItem
+		}
+		if err := checkPreconditions(req, *it, true); err != nil {
+			return err
+		}
+		if in.From != nil && it.Category != from {
+			return errWrongCategory
+		}
+		it.Category = to
+		return nil
+	})
+	switch err {
+	case nil:
+		w.Header().Set("ETag", etag(it))
+		writeJSON(w, http.StatusOK, it)
+	case errNoSuchItem:
+		writeError(w, http.StatusNotFound, "no such item: %q", name)
+	case errWrongCategory:
+		writeError(w, http.StatusConflict, "%v", err)
+	default:
+		writeStoreError(w, err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestCategoriesAndTags checks that lists can be scoped to a category, its
+// subcategories included, or to a tag, and that moves keep the indexes in
+// step
+func TestCategoriesAndTags(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	h := s.handler()
+	for name, body := range map[string]string{
+		"hats":   `{"price": 1, "category": "Clothing", "tags": ["sale", "winter", "sale"]}`,
+		"shirts": `{"price": 2, "category": " clothing / shirts ", "tags": ["summer"]}`,
+		"linen":  `{"price": 3, "category": "clothing/shirts/linen", "tags": ["summer", "sale"]}`,
+		"apples": `{"price": 4, "category": "food"}`,
+	} {
+		if code, resp := send(h, key, "PUT", "/items/"+name, body); code != http.StatusCreated {
+			t.Fatalf("create %s: %d %s", name, code, resp)
+		}
+	}
+
+	for _, c := range []struct {
+		method, path, body string
+		code               int
+		want               string
+	}{
+		{"GET", "/items/hats", "", http.StatusOK, `"category":"clothing","tags":["sale","winter"]`},
+		{"GET", "/list?category=clothing&sort=price", "", http.StatusOK, "hats: $1.00\nshirts: $2.00\nlinen: $3.00\n"},
+		{"GET", "/list?category=clothing/shirts&sort=price&order=desc", "", http.StatusOK, "linen: $3.00\nshirts: $2.00\n"},
+		{"GET", "/list?category=cloth", "", http.StatusOK, ""},
+		{"GET", "/list?tag=sale", "", http.StatusOK, "hats: $1.00\nlinen: $3.00\n"},
+		{"GET", "/list?tag=summer&category=clothing/shirts/linen", "", http.StatusOK, "linen: $3.00\n"},
+		{"GET", "/categories", "", http.StatusOK, `{"categories":[{"category":"clothing","items":1,"total":3},{"category":"clothing/shirts","items":1,"total":2},{"category":"clothing/shirts/linen","items":1,"total":1},{"category":"food","items":1,"total":1}]}`},
+		{"GET", "/tags", "", http.StatusOK, `{"tags":[{"tag":"sale","items":2},{"tag":"summer","items":2},{"tag":"winter","items":1}]}`},
+		{"POST", "/items/hats/move", `{"to": "accessories", "from": "food"}`, http.StatusConflict, "no longer in the expected category"},
+		{"POST", "/items/hats/move", `{"to": "accessories", "from": "clothing"}`, http.StatusOK, `"category":"accessories"`},
+		{"POST", "/items/gloves/move", `{"to": "accessories"}`, http.StatusNotFound, "no such item"},
+		{"GET", "/list?category=clothing&sort=price", "", http.StatusOK, "shirts: $2.00\nlinen: $3.00\n"},
+		{"GET", "/list?category=accessories", "", http.StatusOK, "hats: $1.00\n"},
+		{"PUT", "/items/hats", `{"category": "a//b"}`, http.StatusUnprocessableEntity, "empty level"},
+		{"PUT", "/items/hats", `{"tags": ["a,b"]}`, http.StatusUnprocessableEntity, "must not contain"},
+		{"PUT", "/items/hats", `{"tags": []}`, http.StatusOK, `"name":"hats"`},
+		{"GET", "/tags", "", http.StatusOK, `{"tags":[{"tag":"sale","items":1},{"tag":"summer","items":2}]}`},
+	} {
+		if code, body := send(h, key, c.method, c.path, c.body); code != c.code || !strings.Contains(body, c.want) {
+			t.Errorf("%s %s %s: %d %q; want %d containing %q", c.method, c.path, c.body, code, body, c.code, c.want)
+		}
+	}
+}
+
+// TestCanonicalLabels checks that categories and tags are canonical in the
+// same way as names, so spellings that differ only in case, composition or
+// folding are one category or tag
+func TestCanonicalLabels(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	h := s.handler()
+	for name, body := range map[string]string{
+		"hats":  `{"price": 1, "category": " Café / Straße ", "tags": ["SALE", "Straße"]}`,
+		"socks": `{"price": 1, "category": "café/STRASSE", "tags": ["sale", "strasse"]}`,
+	} {
+		if code, resp := send(h, key, "PUT", "/items/"+name, body); code != http.StatusCreated {
+			t.Fatalf("create %s: %d %s", name, code, resp)
+		}
+	}
+	for _, c := range []struct{ path, want string }{
+		{"/list?category=CAF%C3%89", "hats: $1.00\nsocks: $1.00\n"},
+		{"/list?category=caf%C3%A9/strasse", "hats: $1.00\nsocks: $1.00\n"},
+		{"/list?tag=STRA%C3%9FE", "hats: $1.00\nsocks: $1.00\n"},
+		{"/categories", `"category":"café/strasse","items":2`},
+		{"/tags", `"tag":"strasse","items":2`},
+	} {
+		if code, body := send(h, key, "GET", c.path, ""); code != http.StatusOK || !strings.Contains(body, c.want) {
+			t.Errorf("GET %s: %d %q; want %q", c.path, code, body, c.want)
+		}
+	}
+}
+
+// TestMigrateLabels checks that categories and tags stored when they were
+// only lower-cased are made canonical, index entries included
+func TestMigrateLabels(t *testing.T) {
+	for name, st := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := prepareStore(st); err != nil {
+				t.Fatal(err)
+			}
+			if err := st.Tx(true, func(tx StoreTx) error {
+				if err := tx.Put(item{Name: "hats", Price: 100, Unit: defaultUnit, Category: "straße/hüte", Tags: []string{"straße"}}); err != nil {
+					return err
+				}
+				return tx.Bucket(metaBucket).Put(schemaKey, Uint64ToBytes(6))
+			}); err != nil {
+				t.Fatal(err)
+			}
+			s, key := newTestServer(t, st)
+			h := s.handler()
+			for _, path := range []string{"/list?category=strasse", "/list?tag=strasse", "/list?category=STRASSE/H%C3%9CTE"} {
+				if code, body := send(h, key, "GET", path, ""); body != "hats: $1.00\n" {
+					t.Errorf("GET %s: %d %q; want hats", path, code, body)
+				}
+			}
+			if code, body := send(h, key, "GET", "/categories", ""); strings.Contains(body, "ß") {
+				t.Errorf("categories after migration: %d %s", code, body)
+			}
+		})
+	}
+}
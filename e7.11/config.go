@@ -0,0 +1,209 @@
+/* Server configuration.
+Settings come from flags and, with -config FILE, a JSON file; flags given
+on the command line override the file. Durations are Go duration strings.
+	{
+		"addr": "localhost:8443",
+		"db": "db/inventory.db",
+		"read_header_timeout": "10s",
+		"read_timeout": "1m",
+		"write_timeout": "1m",
+		"idle_timeout": "2m",
+		"shutdown_timeout": "30s",
+		"tls_cert": "cert.pem",
+		"tls_key": "key.pem",
+		"self_signed": false,
+		"follow": "",
+		"leader_key": "",
+		"low_stock_webhook": "",
+		"legacy": false
+	}
+Streams, long polls, exports, imports, backups and restores are not bound
+by the read and write timeouts (see untimed). On SIGINT or SIGTERM the
+server stops accepting connections, ends /events streams and waits up to
+shutdown_timeout for requests in progress before closing the database.
+See tls.go for TLS and self-signed certificates. */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// config holds the settings of item_server
+type config struct {
+	Addr              string   `json:"addr"`
+	DB                string   `json:"db"`
+	ReadHeaderTimeout duration `json:"read_header_timeout"`
+	ReadTimeout       duration `json:"read_timeout"`  // whole request, body included
+	WriteTimeout      duration `json:"write_timeout"` // from the end of the headers to the end of the response
+	IdleTimeout       duration `json:"idle_timeout"`  // between requests on a keep-alive connection
+	ShutdownTimeout   duration `json:"shutdown_timeout"`
+	TLSCert           string   `json:"tls_cert"`
+	TLSKey            string   `json:"tls_key"`
+	SelfSigned        bool     `json:"self_signed"`
+	Follow            string   `json:"follow"`
+	LeaderKey         string   `json:"leader_key"`
+	LowStockWebhook   string   `json:"low_stock_webhook"`
+	Legacy            bool     `json:"legacy"`
+}
+
+// duration is a time.Duration read from JSON as a string such as "30s"
+type duration time.Duration
+
+// UnmarshalJSON parses a Go duration string
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("durations must be strings such as \"30s\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// defaultConfig returns the settings used when none are given
+func defaultConfig() config {
+	return config{
+		Addr:              "localhost:8000",
+		DB:                "db/inventory.db",
+		ReadHeaderTimeout: duration(10 * time.Second),
+		ReadTimeout:       duration(time.Minute),
+		WriteTimeout:      duration(time.Minute),
+		IdleTimeout:       duration(2 * time.Minute),
+		ShutdownTimeout:   duration(30 * time.Second),
+		LeaderKey:         os.Getenv("INVENTORY_LEADER_KEY"),
+	}
+}
+
+// register defines a flag for each setting of c on fs, defaulting to the
+// current values
+func (c *config) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.Addr, "addr", c.Addr, "address to listen on")
+	fs.StringVar(&c.DB, "db", c.DB, "bolt database file")
+	fs.DurationVar((*time.Duration)(&c.ReadHeaderTimeout), "read-header-timeout", time.Duration(c.ReadHeaderTimeout), "time allowed to read request headers")
+	fs.DurationVar((*time.Duration)(&c.ReadTimeout), "read-timeout", time.Duration(c.ReadTimeout), "time allowed to read a whole request; 0 for none")
+	fs.DurationVar((*time.Duration)(&c.WriteTimeout), "write-timeout", time.Duration(c.WriteTimeout), "time allowed to write a response; 0 for none")
+	fs.DurationVar((*time.Duration)(&c.IdleTimeout), "idle-timeout", time.Duration(c.IdleTimeout), "how long to keep idle connections open")
+	fs.DurationVar((*time.Duration)(&c.ShutdownTimeout), "shutdown-timeout", time.Duration(c.ShutdownTimeout), "how long to wait for requests in progress on shutdown")
+	fs.StringVar(&c.TLSCert, "tls-cert", c.TLSCert, "TLS certificate file (PEM); serve HTTPS when set")
+	fs.StringVar(&c.TLSKey, "tls-key", c.TLSKey, "TLS private key file (PEM)")
+	fs.BoolVar(&c.SelfSigned, "self-signed", c.SelfSigned, "serve HTTPS with a self-signed certificate for local development (see tls.go)")
+	fs.StringVar(&c.Follow, "follow", c.Follow, "run as a read-only follower of the leader at this URL (see replication.go)")
+	fs.StringVar(&c.LeaderKey, "leader-key", c.LeaderKey, "admin API key for the leader; default $INVENTORY_LEADER_KEY")
+	fs.StringVar(&c.LowStockWebhook, "low-stock-webhook", c.LowStockWebhook, "POST low-stock alerts to this URL (see webhook.go)")
+	fs.BoolVar(&c.Legacy, "legacy", c.Legacy, "also serve the old /update and /delete endpoints, which change the inventory on GET")
+}
+
+// parseConfig reads the settings from the command line arguments and the
+// config file they name, if any
+func parseConfig(args []string) (config, error) {
+	cfg := defaultConfig()
+	fs := flag.NewFlagSet("item_server", flag.ExitOnError)
+	path := fs.String("config", "", "JSON config file; flags override its settings")
+	cfg.register(fs)
+	fs.Parse(args)
+	if *path != "" {
+		// load the file over the defaults, then apply the flags again
+		set := make(map[string]string)
+		fs.Visit(func(f *flag.Flag) { set[f.Name] = f.Value.String() })
+		cfg = defaultConfig()
+		if err := cfg.load(*path); err != nil {
+			return cfg, err
+		}
+		for name, value := range set {
+			fs.Set(name, value)
+		}
+	}
+	return cfg, cfg.check()
+}
+
+// load reads settings from the JSON file at path, leaving settings the file
+// does not mention unchanged
+func (c *config) load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not read config: %v", err)
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("config %s: %v", path, err)
+	}
+	return nil
+}
+
+// check rejects inconsistent settings
+func (c config) check() error {
+	switch {
+	case c.Addr == "" || c.DB == "":
+		return errors.New("addr and db must not be empty")
+	case c.ReadHeaderTimeout < 0 || c.ReadTimeout < 0 || c.WriteTimeout < 0 || c.IdleTimeout < 0 || c.ShutdownTimeout < 0:
+		return errors.New("timeouts must not be negative")
+	case (c.TLSCert == "") != (c.TLSKey == ""):
+		return errors.New("tls_cert and tls_key must be given together")
+	}
+	return nil
+}
+
+// httpServer returns a server for h with the configured address and
+// timeouts
+func (c config) httpServer(h http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              c.Addr,
+		Handler:           h,
+		ReadHeaderTimeout: time.Duration(c.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(c.ReadTimeout),
+		WriteTimeout:      time.Duration(c.WriteTimeout),
+		IdleTimeout:       time.Duration(c.IdleTimeout),
+	}
+}
+
+// serve runs srv until it fails or ctx is done. It then shuts srv down,
+// waiting up to timeout for requests in progress to finish.
+func serve(ctx context.Context, srv *http.Server, timeout time.Duration) error {
+	errc := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			errc <- srv.ListenAndServeTLS("", "") // certificates are in TLSConfig
+		} else {
+			errc <- srv.ListenAndServe()
+		}
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Print("shutting down: draining requests in progress")
+	sctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(sctx); err != nil {
+		srv.Close()
+		return fmt.Errorf("shutdown: %v; remaining connections closed", err)
+	}
+	return nil
+}
+
+// untimed lifts the server's read and write deadlines for h, which serves
+// streams or transfers that may rightly outlast them
+func untimed(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		rc := http.NewResponseController(w)
+		rc.SetReadDeadline(time.Time{}) // not supported by every ResponseWriter, e.g. in tests
+		rc.SetWriteDeadline(time.Time{})
+		h(w, req)
+	}
+}
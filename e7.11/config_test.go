@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestParseConfig checks that a config file is read over the defaults and
+// that flags override it
+func TestParseConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"addr": ":9000", "db": "file.db", "write_timeout": "5s", "idle_timeout": "0s"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := parseConfig([]string{"-db", "flag.db", "-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := defaultConfig()
+	want.Addr, want.DB, want.WriteTimeout, want.IdleTimeout = ":9000", "flag.db", duration(5*time.Second), 0
+	if cfg != want {
+		t.Errorf("config:\n got %+v\nwant %+v", cfg, want)
+	}
+
+	for body, msg := range map[string]string{
+		`{"addr": ":9000", "port": 1}`: "unknown field",
+		`{"read_timeout": 30}`:         "durations must be strings",
+		`{"read_timeout": "soon"}`:     "invalid duration",
+		`{"tls_cert": "cert.pem"}`:     "must be given together",
+		`{"shutdown_timeout": "-1s"}`:  "must not be negative",
+		`{"addr": ""}`:                 "must not be empty",
+	} {
+		if err := os.WriteFile(path, []byte(body), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parseConfig([]string{"-config", path}); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("config %s: %v; want an error containing %q", body, err, msg)
+		}
+	}
+	if _, err := parseConfig([]string{"-config", filepath.Join(t.TempDir(), "none.json")}); err == nil {
+		t.Error("a missing config file was accepted")
+	}
+}
+
+// TestSelfSigned checks that a self-signed certificate is written to the
+// files named, and read back from them next time
+func TestSelfSigned(t *testing.T) {
+	dir := t.TempDir()
+	cfg := defaultConfig()
+	cfg.SelfSigned, cfg.TLSCert, cfg.TLSKey = true, filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	first, err := cfg.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := cfg.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(first.Certificates[0].Certificate[0]) != string(second.Certificates[0].Certificate[0]) {
+		t.Error("a second start made a new certificate")
+	}
+	if tc, err := defaultConfig().tlsConfig(); tc != nil || err != nil {
+		t.Errorf("tlsConfig without TLS settings: %v, %v; want plain HTTP", tc, err)
+	}
+}
+
+// TestShutdownEndsStreams checks that shutting down ends /events streams
+// rather than waiting for them
+func TestShutdownEndsStreams(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+	expect := streamEvents(t, ts, key, "/events")
+	expect("retry: 2000")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.feed.stop()
+	ts.Config.SetKeepAlivesEnabled(false)
+	if err := ts.Config.Shutdown(ctx); err != nil {
+		t.Errorf("shutdown with an open stream: %v", err)
+	}
+}
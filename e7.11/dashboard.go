@@ -0,0 +1,652 @@
+/* Browser dashboard.
+GET  /ui                        items a page at a time, and recent changes
+GET  /ui/login                  sign in with an API key
+POST /ui/login
+POST /ui/logout
+POST /ui/items                  add an item (editor)
+POST /ui/items/{name}/price     change an item's price (editor)
+POST /ui/items/{name}/delete    delete an item (editor)
+Pages are rendered on the server with html/template and carry their own
+styles and no scripts, so the dashboard needs nothing but the server.
+The item table is read with a listQuery (see list.go), so it is paged
+and sorted by name or price the same way as GET /items.
+Signing in with an API key starts a session that lives in memory (so a
+restart signs everyone out) and is named by an HttpOnly, SameSite=Strict
+cookie. The key is looked up on every request, so revoking it ends its
+sessions. Every form carries the session's CSRF token, and POSTs from
+another origin are refused. A price edit or delete made from a page that
+is out of date is refused rather than applied over the newer change. */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Dashboard settings
+const (
+	sessionCookie    = "inventory_session"
+	loginCookie      = "inventory_login" // CSRF token of the login form
+	sessionLifetime  = 12 * time.Hour
+	dashboardChanges = 20 // recent changes shown
+	dashboardPage    = 50 // items shown per page
+)
+
+// session is a signed-in dashboard user
+type session struct {
+	id      string
+	keyID   string // the API key that signed in; see auth.go
+	csrf    string // token every form of the session must carry
+	flash   string // message for the next page, e.g. "price saved"
+	expires time.Time
+}
+
+// sessionStore holds the dashboard sessions in memory
+type sessionStore struct {
+	mu sync.Mutex
+	m  map[string]*session
+}
+
+// newSessionStore returns an empty session store
+func newSessionStore() *sessionStore {
+	return &sessionStore{m: make(map[string]*session)}
+}
+
+// create starts a session for the key with id keyID
+func (st *sessionStore) create(keyID string) (session, error) {
+	id, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return session{}, err
+	}
+	csrf, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return session{}, err
+	}
+	now := time.Now()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for k, sess := range st.m {
+		if now.After(sess.expires) {
+			delete(st.m, k)
+		}
+	}
+	sess := &session{id: id, keyID: keyID, csrf: csrf, expires: now.Add(sessionLifetime)}
+	st.m[id] = sess
+	return *sess, nil
+}
+
+// get returns the unexpired session id
+func (st *sessionStore) get(id string) (session, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	sess, ok := st.m[id]
+	if !ok || time.Now().After(sess.expires) {
+		delete(st.m, id)
+		return session{}, false
+	}
+	return *sess, true
+}
+
+// end deletes session id
+func (st *sessionStore) end(id string) {
+	st.mu.Lock()
+	delete(st.m, id)
+	st.mu.Unlock()
+}
+
+// setFlash leaves msg for the next page shown to session id
+func (st *sessionStore) setFlash(id, msg string) {
+	st.mu.Lock()
+	if sess, ok := st.m[id]; ok {
+		sess.flash = msg
+	}
+	st.mu.Unlock()
+}
+
+// takeFlash returns and clears the message left for session id
+func (st *sessionStore) takeFlash(id string) string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	sess, ok := st.m[id]
+	if !ok {
+		return ""
+	}
+	msg := sess.flash
+	sess.flash = ""
+	return msg
+}
+
+// dashboardHandler serves a request made in a session
+type dashboardHandler func(w http.ResponseWriter, req *http.Request, sess session)
+
+// dashboardAuth wraps h so that it only runs in a session whose key is
+// still active and has at least role. Requests without one are sent to
+// the login page; POSTs must also pass the CSRF checks.
+func (s *server) dashboardAuth(role string, h dashboardHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		dashboardHeaders(w)
+		var sess session
+		var k apiKey
+		ok := false
+		if c, err := req.Cookie(sessionCookie); err == nil {
+			if sess, ok = s.sessions.get(c.Value); ok {
+				var found bool
+				k, found, err = s.loadKey(sess.keyID)
+				if ok = err == nil && found && k.Revoked == nil; !ok {
+					s.sessions.end(sess.id)
+				}
+			}
+		}
+		if !ok {
+			http.Redirect(w, req, "/ui/login", http.StatusSeeOther)
+			return
+		}
+		if req.Method == http.MethodPost {
+			req.Body = http.MaxBytesReader(w, req.Body, maxBodyBytes)
+			if !sameOrigin(req) || !tokensEqual(req.PostFormValue("csrf"), sess.csrf) {
+				renderDashboard(w, http.StatusForbidden, "error", "The form has expired or came from another site. Reload the page and try again.")
+				return
+			}
+		}
+		if roleRank[k.Role] < roleRank[role] {
+			renderDashboard(w, http.StatusForbidden, "error", fmt.Sprintf("A %s key cannot do this; %s role required.", k.Role, role))
+			return
+		}
+		h(w, req.WithContext(context.WithValue(req.Context(), apiKeyCtx, k)), sess)
+	}
+}
+
+// dashboardHeaders forbids framing, caching and anything but inline styles
+func dashboardHeaders(w http.ResponseWriter) {
+	h := w.Header()
+	h.Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; form-action 'self'; frame-ancestors 'none'; base-uri 'none'")
+	h.Set("X-Frame-Options", "DENY")
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Referrer-Policy", "same-origin")
+	h.Set("Cache-Control", "no-store")
+}
+
+// sameOrigin reports whether req was sent by a page of this server.
+// Browsers send Origin with every POST; a request without it is not from
+// another site's page.
+func sameOrigin(req *http.Request) bool {
+	o := req.Header.Get("Origin")
+	if o == "" {
+		return true
+	}
+	u, err := url.Parse(o)
+	return err == nil && u.Host == req.Host
+}
+
+// tokensEqual compares a presented token with the expected one in
+// constant time
+func tokensEqual(presented, want string) bool {
+	return want != "" && subtle.ConstantTimeCompare([]byte(presented), []byte(want)) == 1
+}
+
+// setCookie sets a dashboard cookie; maxAge < 0 deletes it
+func setCookie(w http.ResponseWriter, req *http.Request, name, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/ui",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   req.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// Sign-in form: GET /ui/login
+func (s *server) loginPage(w http.ResponseWriter, req *http.Request) {
+	dashboardHeaders(w)
+	if c, err := req.Cookie(sessionCookie); err == nil {
+		if _, ok := s.sessions.get(c.Value); ok {
+			http.Redirect(w, req, "/ui", http.StatusSeeOther)
+			return
+		}
+	}
+	s.renderLogin(w, req, http.StatusOK, "")
+}
+
+// renderLogin shows the sign-in form with a fresh CSRF token, which is
+// also set as a cookie for login to compare with the form
+func (s *server) renderLogin(w http.ResponseWriter, req *http.Request, status int, msg string) {
+	token, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		renderDashboard(w, http.StatusInternalServerError, "error", "Could not start a session.")
+		return
+	}
+	setCookie(w, req, loginCookie, token, 0)
+	renderDashboard(w, status, "login", struct{ CSRF, Error string }{token, msg})
+}
+
+// Sign in with an API key: POST /ui/login
+func (s *server) login(w http.ResponseWriter, req *http.Request) {
+	dashboardHeaders(w)
+	req.Body = http.MaxBytesReader(w, req.Body, maxBodyBytes)
+	c, err := req.Cookie(loginCookie)
+	if err != nil || !sameOrigin(req) || !tokensEqual(req.PostFormValue("csrf"), c.Value) {
+		s.renderLogin(w, req, http.StatusForbidden, "The form has expired. Please try again.")
+		return
+	}
+	k, err := s.verifyKey(strings.TrimSpace(req.PostFormValue("key")))
+	if err != nil {
+		s.renderLogin(w, req, http.StatusUnauthorized, "That key is not valid.")
+		return
+	}
+	sess, err := s.sessions.create(k.ID)
+	if err != nil {
+		renderDashboard(w, http.StatusInternalServerError, "error", "Could not start a session.")
+		return
+	}
+	setCookie(w, req, loginCookie, "", -1)
+	setCookie(w, req, sessionCookie, sess.id, int(sessionLifetime/time.Second))
+	http.Redirect(w, req, "/ui", http.StatusSeeOther)
+}
+
+// Sign out: POST /ui/logout
+func (s *server) logout(w http.ResponseWriter, req *http.Request, sess session) {
+	s.sessions.end(sess.id)
+	setCookie(w, req, sessionCookie, "", -1)
+	http.Redirect(w, req, "/ui/login", http.StatusSeeOther)
+}
+
+// dashboardColumns are the columns the item table can be sorted by: those
+// a listQuery can walk in order
+var dashboardColumns = []struct{ key, label string }{
+	{"name", "Name"}, {"price", "Price"},
+}
+
+// dashboardColumn is a sortable column heading
+type dashboardColumn struct {
+	Label string
+	URL   string // sorts by the column, reversing the order if it is current
+	Arrow string // shows the order of the current column
+}
+
+// dashboardView holds the sort, filter and page of the item table
+type dashboardView struct {
+	Sort   string
+	Desc   bool
+	Query  string
+	Cursor string // next_cursor of the previous page; "" for the first
+}
+
+// parseDashboardView reads a view from query or form values, falling back
+// to name order for anything unknown
+func parseDashboardView(v url.Values) dashboardView {
+	view := dashboardView{Sort: "name", Desc: v.Get("order") == "desc", Query: strings.TrimSpace(v.Get("q")), Cursor: v.Get("cursor")}
+	for _, c := range dashboardColumns {
+		if v.Get("sort") == c.key {
+			view.Sort = c.key
+		}
+	}
+	return view
+}
+
+// url returns the dashboard address showing view
+func (view dashboardView) url() string {
+	v := url.Values{}
+	if view.Sort != "name" {
+		v.Set("sort", view.Sort)
+	}
+	if view.Desc {
+		v.Set("order", "desc")
+	}
+	if view.Query != "" {
+		v.Set("q", view.Query)
+	}
+	if view.Cursor != "" {
+		v.Set("cursor", view.Cursor)
+	}
+	if len(v) == 0 {
+		return "/ui"
+	}
+	return "/ui?" + v.Encode()
+}
+
+// listQuery returns the query for the page of items view shows. A cursor
+// from another sort order is refused, as it is by GET /items.
+func (view dashboardView) listQuery() (listQuery, error) {
+	return parseListQuery(url.Values{
+		"sort":   {view.Sort},
+		"order":  {view.Order()},
+		"q":      {view.Query},
+		"limit":  {strconv.Itoa(dashboardPage)},
+		"cursor": {view.Cursor},
+	})
+}
+
+// Order returns the value of the order parameter of view
+func (view dashboardView) Order() string {
+	if view.Desc {
+		return "desc"
+	}
+	return "asc"
+}
+
+// Items and recent changes: GET /ui
+func (s *server) dashboard(w http.ResponseWriter, req *http.Request, sess session) {
+	view := parseDashboardView(req.URL.Query())
+	lq, err := view.listQuery()
+	if err != nil {
+		renderDashboard(w, http.StatusBadRequest, "error", fmt.Sprintf("Could not show that page: %v.", err))
+		return
+	}
+	var items []item
+	var changes []change
+	var next string
+	err = s.store.Tx(false, func(tx StoreTx) (err error) {
+		if items, next, err = queryTx(tx, lq); err != nil {
+			return err
+		}
+		changes, err = recentChanges(tx, 0, dashboardChanges)
+		return err
+	})
+	if err != nil {
+		renderDashboard(w, http.StatusInternalServerError, "error", fmt.Sprintf("Could not read the database: %v", err))
+		return
+	}
+	first, more := view, view
+	first.Cursor, more.Cursor = "", next
+
+	var columns []dashboardColumn
+	for _, c := range dashboardColumns {
+		next := dashboardView{Sort: c.key, Query: view.Query}
+		col := dashboardColumn{Label: c.label}
+		if c.key == view.Sort {
+			next.Desc = !view.Desc
+			col.Arrow = "▲"
+			if view.Desc {
+				col.Arrow = "▼"
+			}
+		}
+		col.URL = next.url()
+		columns = append(columns, col)
+	}
+	k, _ := keyOf(req)
+	renderDashboard(w, http.StatusOK, "dashboard", struct {
+		Key      apiKey
+		CanEdit  bool
+		Follower bool
+		CSRF     string
+		Flash    string
+		View     dashboardView
+		Columns  []dashboardColumn
+		Items    []item
+		First    string // the first page, if this is a later one
+		Next     string // the next page, if there is one
+		Changes  []change
+	}{
+		Key:      k,
+		CanEdit:  roleRank[k.Role] >= roleRank[roleEditor] && s.replica == nil,
+		Follower: s.replica != nil,
+		CSRF:     sess.csrf,
+		Flash:    s.sessions.takeFlash(sess.id),
+		View:     view,
+		Columns:  columns,
+		Items:    items,
+		First:    pageURL(view.Cursor != "", first),
+		Next:     pageURL(next != "", more),
+		Changes:  changes,
+	})
+}
+
+// pageURL returns the address of view if ok, and "" if not
+func pageURL(ok bool, view dashboardView) string {
+	if !ok {
+		return ""
+	}
+	return view.url()
+}
+
+// Add an item: POST /ui/items
+func (s *server) dashboardAdd(w http.ResponseWriter, req *http.Request, sess session) {
+	name := canonicalName(req.PostFormValue("name"))
+	msg := func() string {
+		price, err := parseFormPrice(req.PostFormValue("price"))
+		if err != nil {
+			return err.Error()
+		}
+		var qty int64
+		if q := strings.TrimSpace(req.PostFormValue("quantity")); q != "" {
+			if qty, err = strconv.ParseInt(q, 10, 64); err != nil {
+				return "Quantity must be a whole number."
+			}
+		}
+		_, _, err = s.save(actorOf(req), name, func(it *item, created bool) error {
+			if !created {
+				return errItemExists
+			}
+			it.Price, it.Quantity = price, qty
+			return nil
+		})
+		return dashboardResult(err, name, fmt.Sprintf("Added %s.", name))
+	}()
+	s.sessions.setFlash(sess.id, msg)
+	http.Redirect(w, req, parseDashboardView(req.PostForm).url(), http.StatusSeeOther)
+}
+
+// Change a price: POST /ui/items/{name}/price
+func (s *server) dashboardPrice(w http.ResponseWriter, req *http.Request, sess session) {
+	name := itemName(req)
+	msg := func() string {
+		price, err := parseFormPrice(req.PostFormValue("price"))
+		if err != nil {
+			return err.Error()
+		}
+		version, _ := strconv.ParseUint(req.PostFormValue("version"), 10, 64)
+		it, _, err := s.save(actorOf(req), name, func(it *item, created bool) error {
+			if created {
+				return errNoSuchItem
+			}
+			if it.Version != version {
+				return errPrecondition
+			}
+			it.Price = price
+			return nil
+		})
+		return dashboardResult(err, name, fmt.Sprintf("Set the price of %s to %s.", name, it.Price))
+	}()
+	s.sessions.setFlash(sess.id, msg)
+	http.Redirect(w, req, parseDashboardView(req.PostForm).url(), http.StatusSeeOther)
+}
+
+// Delete an item: POST /ui/items/{name}/delete
+func (s *server) dashboardDelete(w http.ResponseWriter, req *http.Request, sess session) {
+	name := itemName(req)
+	version, _ := strconv.ParseUint(req.PostFormValue("version"), 10, 64)
+	err := s.remove(actorOf(req), name, func(it item) error {
+		if it.Version != version {
+			return errPrecondition
+		}
+		return nil
+	})
+	s.sessions.setFlash(sess.id, dashboardResult(err, name, fmt.Sprintf("Deleted %s.", name)))
+	http.Redirect(w, req, parseDashboardView(req.PostForm).url(), http.StatusSeeOther)
+}
+
+// parseFormPrice reads a price typed into a form, with or without "$"
+func parseFormPrice(s string) (dollars, error) {
+	d, err := parseDollars(strings.TrimPrefix(strings.TrimSpace(s), "$"))
+	if err == nil {
+		err = checkPrice(d)
+	}
+	return d, err
+}
+
+// dashboardResult describes the outcome of a change to name for the user
+func dashboardResult(err error, name, done string) string {
+	switch err.(type) {
+	case nil:
+		return done
+	case invalidError:
+		return err.Error()
+	}
+	switch err {
+	case errItemExists:
+		return fmt.Sprintf("%s already exists.", name)
+	case errNoSuchItem:
+		return fmt.Sprintf("%s no longer exists.", name)
+	case errPrecondition:
+		return fmt.Sprintf("%s was changed by someone else; check it and try again.", name)
+	}
+	log.Printf("dashboard: %s: %v", name, err)
+	return fmt.Sprintf("Could not change %s: %v", name, err)
+}
+
+// describeChange summarizes a change for the history table
+func describeChange(ch change) string {
+	switch {
+	case ch.Old == nil && ch.New != nil:
+		return fmt.Sprintf("created at %s, quantity %d", ch.New.Price, ch.New.Quantity)
+	case ch.New == nil:
+		return "deleted"
+	}
+	var parts []string
+	if ch.Old.Price != ch.New.Price {
+		parts = append(parts, fmt.Sprintf("price %s → %s", ch.Old.Price, ch.New.Price))
+	}
+	if ch.Old.Quantity != ch.New.Quantity {
+		parts = append(parts, fmt.Sprintf("quantity %d → %d", ch.Old.Quantity, ch.New.Quantity))
+	}
+	if ch.Old.Category != ch.New.Category {
+		parts = append(parts, fmt.Sprintf("category %q → %q", ch.Old.Category, ch.New.Category))
+	}
+	if len(parts) == 0 {
+		return "details changed"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// renderDashboard executes the named template into the response
+func renderDashboard(w http.ResponseWriter, status int, name string, data interface{}) {
+	var buf bytes.Buffer
+	if err := dashboardTemplates.ExecuteTemplate(&buf, name, data); err != nil {
+		log.Printf("dashboard: rendering %s: %v", name, err)
+		http.Error(w, "could not render page", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+// dashboardTemplates are the dashboard's pages
+var dashboardTemplates = template.Must(template.New("").Funcs(template.FuncMap{
+	"path":     url.PathEscape,
+	"decimal":  func(d dollars) string { return d.decimal() },
+	"when":     func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04:05") },
+	"describe": describeChange,
+}).Parse(dashboardHTML))
+
+const dashboardHTML = `
+{{define "head"}}<!DOCTYPE html>
+<html lang="en"><head><meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.}} · Inventory</title>
+<style>
+body { font: 14px/1.4 system-ui, sans-serif; margin: 0 auto; max-width: 72em; padding: 1em; color: #222; }
+header { display: flex; justify-content: space-between; align-items: center; border-bottom: 1px solid #ccc; margin-bottom: 1em; }
+h1 { font-size: 1.4em; } h2 { font-size: 1.1em; margin-top: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: .3em .5em; border-bottom: 1px solid #eee; vertical-align: middle; }
+th a { color: inherit; text-decoration: none; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; }
+form.inline { display: inline; margin: 0; }
+input[type=text], input[type=password] { padding: .2em .4em; }
+input.price { width: 7em; text-align: right; }
+button { padding: .2em .6em; cursor: pointer; }
+button.danger { color: #a00; }
+.flash { background: #eef6ee; border: 1px solid #9c9; padding: .5em 1em; }
+.error { background: #fbeeee; border: 1px solid #c99; padding: .5em 1em; }
+.muted { color: #777; }
+fieldset { border: 1px solid #ddd; margin: 1em 0; }
+</style></head><body>{{end}}
+
+{{define "login"}}{{template "head" "Sign in"}}
+<h1>Inventory</h1>
+{{with .Error}}<p class="error">{{.}}</p>{{end}}
+<form method="post" action="/ui/login">
+<input type="hidden" name="csrf" value="{{.CSRF}}">
+<p><label>API key<br><input type="password" name="key" size="60" autocomplete="current-password" autofocus required></label></p>
+<p><button type="submit">Sign in</button></p>
+</form>
+</body></html>{{end}}
+
+{{define "error"}}{{template "head" "Error"}}
+<h1>Inventory</h1>
+<p class="error">{{.}}</p>
+<p><a href="/ui">Back to the dashboard</a></p>
+</body></html>{{end}}
+
+{{define "view"}}<input type="hidden" name="sort" value="{{.Sort}}"><input type="hidden" name="order" value="{{.Order}}"><input type="hidden" name="q" value="{{.Query}}"><input type="hidden" name="cursor" value="{{.Cursor}}">{{end}}
+
+{{define "dashboard"}}{{template "head" "Dashboard"}}
+<header>
+<h1>Inventory</h1>
+<div>{{.Key.Name}} ({{.Key.Role}}){{if .Follower}} · <span class="muted">read-only follower</span>{{end}}
+<form class="inline" method="post" action="/ui/logout"><input type="hidden" name="csrf" value="{{.CSRF}}"> <button type="submit">Sign out</button></form></div>
+</header>
+{{with .Flash}}<p class="flash">{{.}}</p>{{end}}
+
+<form method="get" action="/ui">
+<input type="hidden" name="sort" value="{{.View.Sort}}"><input type="hidden" name="order" value="{{.View.Order}}">
+<input type="text" name="q" value="{{.View.Query}}" placeholder="Name contains"> <button type="submit">Filter</button>
+</form>
+
+<table>
+<thead><tr>{{range .Columns}}<th><a href="{{.URL}}">{{.Label}} {{.Arrow}}</a></th>{{end}}<th>Quantity</th><th>Updated</th><th>Unit</th><th>Category</th>{{if $.CanEdit}}<th></th>{{end}}</tr></thead>
+<tbody>
+{{range .Items}}<tr>
+<td>{{.Name}}</td>
+<td class="num">{{if $.CanEdit}}<form class="inline" method="post" action="/ui/items/{{path .Name}}/price">
+<input type="hidden" name="csrf" value="{{$.CSRF}}"><input type="hidden" name="version" value="{{.Version}}">
+{{template "view" $.View}}
+<input class="price" type="text" name="price" value="{{decimal .Price}}" inputmode="decimal" aria-label="Price of {{.Name}}"> <button type="submit">Save</button></form>{{else}}{{.Price}}{{end}}</td>
+<td class="num">{{.Quantity}}</td>
+<td>{{when .Updated}}</td>
+<td>{{.Unit}}</td>
+<td>{{.Category}}</td>
+{{if $.CanEdit}}<td><form class="inline" method="post" action="/ui/items/{{path .Name}}/delete">
+<input type="hidden" name="csrf" value="{{$.CSRF}}"><input type="hidden" name="version" value="{{.Version}}">
+{{template "view" $.View}}
+<button class="danger" type="submit">Delete</button></form></td>{{end}}
+</tr>
+{{else}}<tr><td colspan="{{if $.CanEdit}}7{{else}}6{{end}}" class="muted">No items.</td></tr>
+{{end}}</tbody>
+</table>
+{{if or .First .Next}}<p>{{with .First}}<a href="{{.}}">First page</a>{{end}}{{if and .First .Next}} · {{end}}{{with .Next}}<a href="{{.}}">Next page</a>{{end}}</p>{{end}}
+
+{{if .CanEdit}}<form method="post" action="/ui/items"><fieldset><legend>Add an item</legend>
+<input type="hidden" name="csrf" value="{{.CSRF}}">
+{{template "view" .View}}
+<label>Name <input type="text" name="name" required></label>
+<label>Price <input class="price" type="text" name="price" inputmode="decimal" required></label>
+<label>Quantity <input class="price" type="text" name="quantity" inputmode="numeric" value="0"></label>
+<button type="submit">Add</button>
+</fieldset></form>{{end}}
+
+<h2>Recent changes</h2>
+<table>
+<thead><tr><th>#</th><th>Time (UTC)</th><th>Item</th><th>Change</th><th>By</th></tr></thead>
+<tbody>
+{{range .Changes}}<tr><td class="num">{{.Seq}}</td><td>{{when .Time}}</td><td>{{.Item}}</td><td>{{describe .}}</td><td>{{.Actor}}</td></tr>
+{{else}}<tr><td colspan="5" class="muted">No changes yet.</td></tr>
+{{end}}</tbody>
+</table>
+</body></html>{{end}}
+`
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// csrfField finds the CSRF token in a dashboard form
+var csrfField = regexp.MustCompile(`name="csrf" value="([^"]+)"`)
+
+// dashboardRequest sends a dashboard request with cookie, a form for a
+// POST, and any further headers as name, value pairs
+func dashboardRequest(h http.Handler, cookie *http.Cookie, method, target string, form url.Values, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+	if method == http.MethodPost {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// cookieOf returns the cookie name set by rec, or nil
+func cookieOf(rec *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// signIn signs in to the dashboard with key, returning the session cookie
+// and the session's CSRF token
+func signIn(t *testing.T, h http.Handler, key string) (*http.Cookie, string) {
+	t.Helper()
+	rec := dashboardRequest(h, nil, "GET", "/ui/login", nil)
+	m := csrfField.FindStringSubmatch(rec.Body.String())
+	if m == nil {
+		t.Fatalf("login page has no CSRF token: %s", rec.Body)
+	}
+	rec = dashboardRequest(h, cookieOf(rec, loginCookie), "POST", "/ui/login", url.Values{"csrf": {m[1]}, "key": {key}})
+	sess := cookieOf(rec, sessionCookie)
+	if rec.Code != http.StatusSeeOther || sess == nil {
+		t.Fatalf("login: %d %s", rec.Code, rec.Body)
+	}
+	rec = dashboardRequest(h, sess, "GET", "/ui", nil)
+	if m = csrfField.FindStringSubmatch(rec.Body.String()); rec.Code != http.StatusOK || m == nil {
+		t.Fatalf("dashboard: %d %s", rec.Code, rec.Body)
+	}
+	return sess, m[1]
+}
+
+// TestDashboardLogin checks that signing in takes a valid key and the
+// login form's own CSRF token
+func TestDashboardLogin(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	h := s.handler()
+
+	if rec := dashboardRequest(h, nil, "GET", "/ui", nil); rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/ui/login" {
+		t.Errorf("dashboard signed out: %d to %q; want the login page", rec.Code, rec.Header().Get("Location"))
+	}
+	page := dashboardRequest(h, nil, "GET", "/ui/login", nil)
+	token := csrfField.FindStringSubmatch(page.Body.String())[1]
+	login := cookieOf(page, loginCookie)
+	for _, c := range []struct {
+		desc   string
+		cookie *http.Cookie
+		form   url.Values
+		header []string
+		code   int
+	}{
+		{"no token", login, url.Values{"key": {key}}, nil, http.StatusForbidden},
+		{"no cookie", nil, url.Values{"csrf": {token}, "key": {key}}, nil, http.StatusForbidden},
+		{"another origin", login, url.Values{"csrf": {token}, "key": {key}}, []string{"Origin", "http://evil.example"}, http.StatusForbidden},
+		{"wrong key", login, url.Values{"csrf": {token}, "key": {"inv_nope"}}, nil, http.StatusUnauthorized},
+		{"valid", login, url.Values{"csrf": {token}, "key": {key}}, []string{"Origin", "http://example.com"}, http.StatusSeeOther},
+	} {
+		rec := dashboardRequest(h, c.cookie, "POST", "/ui/login", c.form, c.header...)
+		if rec.Code != c.code {
+			t.Errorf("login with %s: %d; want %d", c.desc, rec.Code, c.code)
+		}
+		if got := cookieOf(rec, sessionCookie) != nil; got != (c.code == http.StatusSeeOther) {
+			t.Errorf("login with %s: session cookie set %v", c.desc, got)
+		}
+	}
+}
+
+// otherToken returns a token of the same length that differs from token
+func otherToken(token string) string {
+	if token[0] == 'x' {
+		return "y" + token[1:]
+	}
+	return "x" + token[1:]
+}
+
+// TestDashboardCSRF checks that form POSTs need the session's token and
+// must not come from another origin
+func TestDashboardCSRF(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	h := s.handler()
+	sess, token := signIn(t, h, key)
+
+	for _, c := range []struct {
+		desc   string
+		form   url.Values
+		header []string
+		code   int
+	}{
+		{"no token", url.Values{"name": {"hats"}, "price": {"1"}}, nil, http.StatusForbidden},
+		{"another session's token", url.Values{"csrf": {otherToken(token)}, "name": {"hats"}, "price": {"1"}}, nil, http.StatusForbidden},
+		{"another origin", url.Values{"csrf": {token}, "name": {"hats"}, "price": {"1"}}, []string{"Origin", "http://evil.example"}, http.StatusForbidden},
+		{"a malformed origin", url.Values{"csrf": {token}, "name": {"hats"}, "price": {"1"}}, []string{"Origin", "::"}, http.StatusForbidden},
+	} {
+		if rec := dashboardRequest(h, sess, "POST", "/ui/items", c.form, c.header...); rec.Code != c.code {
+			t.Errorf("add with %s: %d; want %d", c.desc, rec.Code, c.code)
+		}
+	}
+	if code, _ := send(h, key, "GET", "/items/hats", ""); code != http.StatusNotFound {
+		t.Fatalf("a refused form created hats: %d", code)
+	}
+
+	rec := dashboardRequest(h, sess, "POST", "/ui/items", url.Values{"csrf": {token}, "name": {"Hats"}, "price": {"$1.50"}}, "Origin", "http://example.com")
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("add: %d %s", rec.Code, rec.Body)
+	}
+	if _, body := send(h, key, "GET", "/price?item=hats", ""); body != "$1.50\n" {
+		t.Errorf("price after add: %q", body)
+	}
+	if rec = dashboardRequest(h, sess, "GET", "/ui", nil); !strings.Contains(rec.Body.String(), "Added hats.") {
+		t.Errorf("dashboard after add lacks the message: %s", rec.Body)
+	}
+}
+
+// TestDashboardRoles checks that a reader's session shows no edit forms and
+// cannot make changes
+func TestDashboardRoles(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	h := s.handler()
+	send(h, key, "PUT", "/items/hats", `{"price": 1}`)
+	_, reader, err := s.issueKey("till-1", roleReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess, token := signIn(t, h, reader)
+
+	if rec := dashboardRequest(h, sess, "GET", "/ui", nil); strings.Contains(rec.Body.String(), "/ui/items/hats/price") {
+		t.Error("a reader's dashboard has a price form")
+	}
+	for _, path := range []string{"/ui/items", "/ui/items/hats/price", "/ui/items/hats/delete"} {
+		rec := dashboardRequest(h, sess, "POST", path, url.Values{"csrf": {token}, "name": {"socks"}, "price": {"2"}, "version": {"1"}})
+		if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "editor role required") {
+			t.Errorf("POST %s as a reader: %d %s", path, rec.Code, rec.Body)
+		}
+	}
+	if _, body := send(h, key, "GET", "/list", ""); body != "hats: $1.00\n" {
+		t.Errorf("list after the reader's attempts: %q", body)
+	}
+}
+
+// TestDashboardLogout checks that signing out ends the session, so its
+// cookie no longer works even if the browser keeps it
+func TestDashboardLogout(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	h := s.handler()
+	sess, token := signIn(t, h, key)
+
+	if rec := dashboardRequest(h, sess, "POST", "/ui/logout", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("logout without a token: %d; want %d", rec.Code, http.StatusForbidden)
+	}
+	rec := dashboardRequest(h, sess, "POST", "/ui/logout", url.Values{"csrf": {token}})
+	if c := cookieOf(rec, sessionCookie); rec.Code != http.StatusSeeOther || c == nil || c.MaxAge >= 0 {
+		t.Errorf("logout: %d, cookie %v; want a redirect deleting the cookie", rec.Code, c)
+	}
+	for _, method := range []string{"GET", "POST"} {
+		rec := dashboardRequest(h, sess, method, "/ui", url.Values{"csrf": {token}})
+		if method == "POST" {
+			rec = dashboardRequest(h, sess, method, "/ui/items", url.Values{"csrf": {token}, "name": {"hats"}, "price": {"1"}})
+		}
+		if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/ui/login" {
+			t.Errorf("%s with the old cookie: %d to %q; want the login page", method, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+}
+
+// TestDashboardPages checks that the item table is read a page at a time
+// with list cursors, in name or price order
+func TestDashboardPages(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	h := s.handler()
+	const n = dashboardPage + 10
+	for i := 0; i < n; i++ {
+		send(h, key, "PUT", fmt.Sprintf("/items/item%02d", i), fmt.Sprintf(`{"price": %d}`, n-i))
+	}
+	sess, _ := signIn(t, h, key)
+	rows := regexp.MustCompile(`<td>(item\d\d)</td>`)
+	next := regexp.MustCompile(`<a href="([^"]+)">Next page</a>`)
+	page := func(target string) (names []string, more string) {
+		t.Helper()
+		rec := dashboardRequest(h, sess, "GET", target, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", target, rec.Code, rec.Body)
+		}
+		table, _, _ := strings.Cut(rec.Body.String(), "Recent changes")
+		for _, m := range rows.FindAllStringSubmatch(table, -1) {
+			names = append(names, m[1])
+		}
+		if m := next.FindStringSubmatch(rec.Body.String()); m != nil {
+			more = strings.ReplaceAll(m[1], "&amp;", "&")
+		}
+		return names, more
+	}
+
+	names, more := page("/ui")
+	if len(names) != dashboardPage || names[0] != "item00" || more == "" {
+		t.Fatalf("first page: %d items from %v, next %q", len(names), names[:1], more)
+	}
+	if names, more = page(more); len(names) != 10 || names[0] != fmt.Sprintf("item%02d", dashboardPage) || more != "" {
+		t.Errorf("second page: %v, next %q", names, more)
+	}
+
+	names, more = page("/ui?sort=price&order=desc") // item00 is dearest
+	if len(names) != dashboardPage || names[0] != "item00" || !strings.Contains(more, "sort=price") {
+		t.Fatalf("first page by price: %d items from %v, next %q", len(names), names[:1], more)
+	}
+	if names, _ = page(more); len(names) != 10 || names[9] != fmt.Sprintf("item%02d", n-1) {
+		t.Errorf("second page by price: %v", names)
+	}
+	if rec := dashboardRequest(h, sess, "GET", strings.Replace(more, "sort=price", "sort=name", 1), nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("a price cursor in name order: %d; want %d", rec.Code, http.StatusBadRequest)
+	}
+}
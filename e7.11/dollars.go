@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// dollars is an exact amount of money held as integer cents (minor units),
+// so values like 19.99 never drift the way float32 did.
+type dollars int64
+
+// maxPrice bounds prices so totals like price*quantity stay far from overflow
+const maxPrice dollars = 1e6 * 100 // $1,000,000.00
+
+// errBadPrice is returned for any price that is not a plain decimal number
+var errBadPrice = errors.New("price must be numerical value with at most two decimal places")
+
+// parseDollars converts a decimal string such as "19.99", "15" or "0.5" to
+// dollars. Exponents, NaN, Inf, signs other than a leading '-' and more than
+// two decimal places are rejected.
+func parseDollars(s string) (dollars, error) {
+	neg := strings.HasPrefix(s, "-")
+	if neg {
+		s = s[1:]
+	}
+	whole, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, frac = s[:i], s[i+1:]
+	}
+	if whole == "" && frac == "" || len(frac) > 2 || !digits(whole) || !digits(frac) {
+		return 0, errBadPrice
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	for len(frac) < 2 {
+		frac += "0"
+	}
+
+	w, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || w > int64(maxPrice/100) {
+		return 0, fmt.Errorf("price must be at most %s", maxPrice)
+	}
+	c, _ := strconv.ParseInt(frac, 10, 64)
+	d := dollars(w*100 + c)
+	if neg {
+		d = -d
+	}
+	return d, nil
+}
+
+// digits reports whether s consists only of ASCII digits
+func digits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// dollarsFromFloat rounds a legacy float price to the nearest cent
+func dollarsFromFloat(f float64) dollars {
+	return dollars(math.Round(f * 100))
+}
+
+// checkPrice verifies price is >= 0 and within range
+func checkPrice(d dollars) error {
+	if d < 0 {
+		return errors.New("price must be greater than or equal to 0")
+	}
+	if d > maxPrice {
+		return fmt.Errorf("price must be at most %s", maxPrice)
+	}
+	return nil
+}
+
+// Add returns d + e
+func (d dollars) Add(e dollars) dollars { return d + e }
+
+// Sub returns d - e
+func (d dollars) Sub(e dollars) dollars { return d - e }
+
+// Mul returns d * n, e.g. a unit price times a quantity
+func (d dollars) Mul(n int64) dollars { return d * dollars(n) }
+
+// decimal formats d as a plain decimal number, e.g. "19.99"
+func (d dollars) decimal() string {
+	sign := ""
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, d/100, d%100)
+}
+
+func (d dollars) String() string {
+	if d < 0 {
+		return "-$" + (-d).decimal()
+	}
+	return "$" + d.decimal()
+}
+
+// MarshalJSON encodes d as an exact JSON number, e.g. 19.99
+func (d dollars) MarshalJSON() ([]byte, error) {
+	return []byte(d.decimal()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or string holding a decimal price
+func (d *dollars) UnmarshalJSON(b []byte) error {
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return errBadPrice
+	}
+	v, err := parseDollars(n.String())
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+// bytes encodes d for storage as 8 big-endian bytes of cents
+func (d dollars) bytes() []byte {
+	return Uint64ToBytes(uint64(d))
+}
+
+// bytesToDollars decodes a value written by dollars.bytes
+func bytesToDollars(bs []byte) dollars {
+	return dollars(int64(BytesToUint64(bs)))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseDollars(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		want dollars
+		ok   bool
+	}{
+		{"19.99", 1999, true},
+		{"15", 1500, true},
+		{"0.5", 50, true},
+		{".5", 50, true},
+		{"-2.25", -225, true},
+		{"1000000", maxPrice, true},
+		{"1000000.01", 100000001, true}, // in range for parsing; checkPrice bounds it
+		{"1000001", 0, false},
+		{"99999999999999999999", 0, false},
+		{"1.999", 0, false},
+		{"1e3", 0, false},
+		{"1E3", 0, false},
+		{"NaN", 0, false},
+		{"Inf", 0, false},
+		{"+5", 0, false},
+		{"--5", 0, false},
+		{"0x10", 0, false},
+		{"", 0, false},
+		{".", 0, false},
+		{"1,50", 0, false},
+		{" 1", 0, false},
+	} {
+		got, err := parseDollars(c.in)
+		if (err == nil) != c.ok || c.ok && got != c.want {
+			t.Errorf("parseDollars(%q) = %d, %v; want %d, ok %v", c.in, got, err, c.want, c.ok)
+		}
+	}
+}
+
+func TestCheckPrice(t *testing.T) {
+	for _, c := range []struct {
+		d  dollars
+		ok bool
+	}{
+		{0, true},
+		{1999, true},
+		{maxPrice, true},
+		{maxPrice + 1, false},
+		{-1, false},
+	} {
+		if err := checkPrice(c.d); (err == nil) != c.ok {
+			t.Errorf("checkPrice(%s) = %v; want ok %v", c.d, err, c.ok)
+		}
+	}
+}
+
+func TestDollarsJSON(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		want dollars
+		ok   bool
+	}{
+		{`19.99`, 1999, true},
+		{`"19.99"`, 1999, true},
+		{`7`, 700, true},
+		{`0.1`, 10, true},
+		{`1e3`, 0, false},
+		{`"NaN"`, 0, false},
+		{`"1e3"`, 0, false},
+		{`19.999`, 0, false},
+		{`true`, 0, false},
+		{`null`, 0, false},
+	} {
+		var d dollars
+		err := json.Unmarshal([]byte(c.in), &d)
+		if (err == nil) != c.ok || c.ok && d != c.want {
+			t.Errorf("unmarshal %s = %d, %v; want %d, ok %v", c.in, d, err, c.want, c.ok)
+		}
+	}
+	for d, want := range map[dollars]string{1999: "19.99", 5: "0.05", 0: "0.00", -250: "-2.50"} {
+		if b, err := json.Marshal(d); err != nil || string(b) != want {
+			t.Errorf("marshal %d = %s, %v; want %s", d, b, err, want)
+		}
+	}
+}
+
+// TestDollarsFromFloat checks that prices stored as float32 by the original
+// server round to the cent they were meant to be
+func TestDollarsFromFloat(t *testing.T) {
+	for _, c := range []struct {
+		f    float32
+		want dollars
+	}{
+		{0, 0},
+		{0.01, 1},
+		{0.1, 10},
+		{0.29, 29},
+		{1.15, 115},
+		{19.99, 1999},
+		{99.95, 9995},
+		{123456.78, 12345678},
+	} {
+		if got := dollarsFromFloat(float64(c.f)); got != c.want {
+			t.Errorf("dollarsFromFloat(%v) = %d; want %d", c.f, got, c.want)
+		}
+	}
+}
+
+// TestMigrateFloatPrices stores a price as the original server did, as the
+// 8 bytes of a float64 holding a float32, and opens the store
+func TestMigrateFloatPrices(t *testing.T) {
+	for name, st := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := prepareStore(st); err != nil {
+				t.Fatal(err)
+			}
+			if err := st.Tx(true, func(tx StoreTx) error {
+				if err := tx.Bucket(inventoryBucket).Put([]byte("hats"), Float64ToBytes(float64(float32(19.99)))); err != nil {
+					return err
+				}
+				return tx.Bucket(metaBucket).Delete(schemaKey)
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := prepareStore(st); err != nil {
+				t.Fatal(err)
+			}
+			if err := st.Tx(false, func(tx StoreTx) error {
+				it, ok, err := tx.Get("hats")
+				if err != nil || !ok || it.Price != 1999 {
+					t.Errorf("hats after migration: %+v, %v, %v; want $19.99", it, ok, err)
+				}
+				if v := BytesToUint64(tx.Bucket(metaBucket).Get(schemaKey)); v != schemaVersion {
+					t.Errorf("schema version %d; want %d", v, schemaVersion)
+				}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
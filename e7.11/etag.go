@@ -0,0 +1,65 @@
+/* Optimistic concurrency for /items/{name}.
+Every stored item carries a version, bumped on each write and sent as the
+ETag of the item. Clients make writes conditional on what they last read:
+	PUT/DELETE with If-Match: "<etag>"   412 if the item changed since
+	PUT with If-None-Match: *            create only; 412 if it exists
+	GET with If-None-Match: "<etag>"     304 if the item is unchanged */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// errPrecondition is returned when an If-Match or If-None-Match condition
+// does not hold
+var errPrecondition = errors.New("precondition failed: item has changed")
+
+// etag returns the entity tag of it. The creation time distinguishes an
+// item from an earlier item of the same name that was deleted.
+func etag(it item) string {
+	return fmt.Sprintf(`"%d-%x"`, it.Version, it.Created.UnixNano())
+}
+
+// matchETag reports whether header, a comma separated list of entity tags
+// or "*", matches tag, which is strong. With weak set, weak tags compare by
+// their opaque part, as If-None-Match requires; otherwise a weak tag never
+// matches, as If-Match requires (RFC 9110, section 8.8.3.2).
+func matchETag(header, tag string, weak bool) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if weak {
+			t = strings.TrimPrefix(t, "W/")
+		}
+		if t == "*" || t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPreconditions evaluates the If-Match and If-None-Match headers of a
+// write against the current item; exists is false if there is none
+func checkPreconditions(req *http.Request, it item, exists bool) error {
+	if h := req.Header.Get("If-Match"); h != "" {
+		if !exists || !matchETag(h, etag(it), false) {
+			return errPrecondition
+		}
+	}
+	if h := req.Header.Get("If-None-Match"); h != "" {
+		if exists && matchETag(h, etag(it), true) {
+			return errPrecondition
+		}
+	}
+	return nil
+}
+
+// notModified reports whether a GET with If-None-Match can be answered
+// with 304 for it
+func notModified(req *http.Request, it item) bool {
+	h := req.Header.Get("If-None-Match")
+	return h != "" && matchETag(h, etag(it), true)
+}
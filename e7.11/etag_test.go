@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestConditionalWrites checks that If-Match and If-None-Match hold writes
+// and reads to the entity tag the client last saw, comparing it strongly
+// for If-Match and weakly for If-None-Match
+func TestConditionalWrites(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	h := s.handler()
+	if code, body := send(h, key, "PUT", "/items/hats", `{"price": 1}`, "If-None-Match", "*"); code != http.StatusCreated {
+		t.Fatalf("create: %d %s", code, body)
+	}
+	// TAG in a value stands for the item's current entity tag
+	for i, c := range []struct {
+		method, header, value string
+		code                  int
+	}{
+		{"PUT", "If-None-Match", "*", http.StatusPreconditionFailed},
+		{"PUT", "If-Match", `"1-0"`, http.StatusPreconditionFailed},
+		{"PUT", "If-Match", "W/TAG", http.StatusPreconditionFailed},
+		{"PUT", "If-None-Match", "W/TAG", http.StatusPreconditionFailed},
+		{"PUT", "If-Match", `"other", TAG`, http.StatusOK},
+		{"PUT", "If-Match", "*", http.StatusOK},
+		{"GET", "If-None-Match", "TAG", http.StatusNotModified},
+		{"GET", "If-None-Match", "W/TAG", http.StatusNotModified},
+		{"GET", "If-None-Match", `"1-0"`, http.StatusOK},
+		{"DELETE", "If-Match", `"1-0"`, http.StatusPreconditionFailed},
+		{"DELETE", "If-Match", "W/TAG", http.StatusPreconditionFailed},
+		{"DELETE", "If-Match", "TAG", http.StatusNoContent},
+		{"DELETE", "If-Match", "*", http.StatusPreconditionFailed}, // gone
+	} {
+		it, _, err := s.lookup("hats")
+		if err != nil {
+			t.Fatal(err)
+		}
+		value := strings.ReplaceAll(c.value, "TAG", etag(it))
+		body := ""
+		if c.method == "PUT" {
+			body = fmt.Sprintf(`{"quantity": %d}`, i) // a change, so the version moves
+		}
+		if code, resp := send(h, key, c.method, "/items/hats", body, c.header, value); code != c.code {
+			t.Errorf("%s with %s: %s: %d %s; want %d", c.method, c.header, value, code, resp, c.code)
+		}
+	}
+}
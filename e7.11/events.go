@@ -0,0 +1,202 @@
+/* Live change feed.
+GET /events  Server-Sent Events stream of every change made through the server
+Each event carries the history sequence number as its id, the operation as
+its type and the change record (see history.go) as its data:
+	id: 42
+	event: update
+	data: {"seq":42,"op":"update","item":"hats",...}
+A client that reconnects with Last-Event-ID (header, or ?last_event_id= for
+clients that cannot set headers) first receives every change after that id
+from the history, so nothing committed while it was away is missed. Without
+one the stream starts at the next change.
+If the history ends before the client's position, because the database was
+restored from an older backup, a reset event is sent with the latest
+sequence number as its id and data, and the stream goes on from there:
+	id: 17
+	event: reset
+	data: {"seq":17}
+The client should then read the inventory again. HEAD /events answers
+with the stream's headers and no body. */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Feed tuning
+const (
+	eventsBatch     = 500              // changes read per transaction
+	eventsHeartbeat = 15 * time.Second // comment sent to keep idle connections open
+	eventsRetry     = 2000             // reconnection delay suggested to clients, ms
+)
+
+// feed wakes subscribers when changes are committed. It carries no data:
+// subscribers read the history themselves, so they see changes in
+// sequence order however commits and wake-ups interleave.
+type feed struct {
+	mu    sync.Mutex
+	ready chan struct{} // closed and replaced on every notify
+	done  chan struct{} // closed when the server shuts down
+	once  sync.Once
+}
+
+// newFeed returns a feed with no pending notification
+func newFeed() *feed {
+	return &feed{ready: make(chan struct{}), done: make(chan struct{})}
+}
+
+// wait returns a channel that is closed at the next notify
+func (f *feed) wait() <-chan struct{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.ready
+}
+
+// notify wakes every subscriber waiting on the feed
+func (f *feed) notify() {
+	f.mu.Lock()
+	close(f.ready)
+	f.ready = make(chan struct{})
+	f.mu.Unlock()
+}
+
+// stop tells subscribers that the server is shutting down, so that they
+// end their streams rather than hold it up
+func (f *feed) stop() {
+	f.once.Do(func() { close(f.done) })
+}
+
+// stopped returns a channel that is closed by stop
+func (f *feed) stopped() <-chan struct{} {
+	return f.done
+}
+
+// changesAfter reads up to limit changes with a sequence number above seq,
+// oldest first
+func changesAfter(tx StoreTx, seq uint64, limit int) ([]change, error) {
+	var list []change
+	c := tx.Bucket(historyBucket).Cursor()
+	k, v := c.Seek(Uint64ToBytes(seq + 1))
+	for ; k != nil && len(list) < limit; k, v = c.Next() {
+		ch, err := decodeChange(v)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, ch)
+	}
+	return list, nil
+}
+
+// lastSeq returns the sequence number of the latest change, or 0
+func lastSeq(tx StoreTx) uint64 {
+	if k, _ := tx.Bucket(historyBucket).Cursor().Last(); k != nil {
+		return BytesToUint64(k)
+	}
+	return 0
+}
+
+// Stream changes: GET /events
+func (s *server) events(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	id := req.Header.Get("Last-Event-ID")
+	if id == "" {
+		id = req.URL.Query().Get("last_event_id")
+	}
+	var seq uint64
+	if id != "" {
+		var err error
+		if seq, err = strconv.ParseUint(id, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, "Last-Event-ID must be a sequence number")
+			return
+		}
+	} else if err := s.store.Tx(false, func(tx StoreTx) error {
+		seq = lastSeq(tx)
+		return nil
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, "could not read history: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // let proxies stream
+	w.WriteHeader(http.StatusOK)
+	if req.Method == http.MethodHead {
+		return // headers only; the stream never ends
+	}
+	fmt.Fprintf(w, "retry: %d\n\n", eventsRetry)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		// take the wake-up channel before reading, so a commit that lands
+		// after the read still wakes us
+		ready := s.feed.wait()
+		for {
+			var list []change
+			var last uint64
+			if err := s.store.Tx(false, func(tx StoreTx) (err error) {
+				if last = lastSeq(tx); last < seq {
+					return nil
+				}
+				list, err = changesAfter(tx, seq, eventsBatch)
+				return err
+			}); err != nil {
+				fmt.Fprintf(w, "event: error\ndata: could not read history: %v\n\n", err)
+				flusher.Flush()
+				return
+			}
+			if last < seq {
+				if _, err := fmt.Fprintf(w, "id: %d\nevent: reset\ndata: {\"seq\":%d}\n\n", last, last); err != nil {
+					return
+				}
+				seq = last
+			}
+			for _, ch := range list {
+				if err := writeEvent(w, ch); err != nil {
+					return // client gone
+				}
+				seq = ch.Seq
+			}
+			flusher.Flush()
+			if len(list) < eventsBatch {
+				break
+			}
+		}
+
+		select {
+		case <-ready:
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		case <-s.feed.stopped():
+			return // the client reconnects with Last-Event-ID
+		}
+	}
+}
+
+// writeEvent sends ch as one event
+func writeEvent(w http.ResponseWriter, ch change) error {
+	data, err := json.Marshal(ch)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ch.Seq, ch.Op, data)
+	return err
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// streamEvents opens the event stream at path on ts with key and any
+// further headers, given as name, value pairs. It returns a function that
+// waits for each of want, in order, among the lines of the stream.
+func streamEvents(t *testing.T, ts *httptest.Server, key, path string, header ...string) func(want ...string) {
+	t.Helper()
+	req, _ := http.NewRequest("GET", ts.URL+path, nil)
+	req.Header.Set("Authorization", "Bearer "+key)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: %s", path, resp.Status)
+	}
+	lines := make(chan string, 100)
+	go func() {
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+		close(lines)
+	}()
+	return func(want ...string) {
+		t.Helper()
+		for _, w := range want {
+			for found := false; !found; {
+				select {
+				case line, ok := <-lines:
+					if !ok {
+						t.Fatalf("stream ended waiting for %q", w)
+					}
+					found = line == w
+				case <-time.After(5 * time.Second):
+					t.Fatalf("no %q in the stream", w)
+				}
+			}
+		}
+	}
+}
+
+// TestEventsResume checks that a stream resumed from a known id first
+// replays the changes after it, then carries on with new ones
+func TestEventsResume(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	h := s.handler()
+	for _, name := range []string{"hats", "socks", "shirts"} { // seq 1 to 3
+		send(h, key, "PUT", "/items/"+name, `{"price": 1}`)
+	}
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close) // after the streams are closed
+
+	header := streamEvents(t, ts, key, "/events", "Last-Event-ID", "1")
+	query := streamEvents(t, ts, key, "/events?last_event_id=2")
+	fresh := streamEvents(t, ts, key, "/events")
+	header("retry: 2000", "id: 2", "event: create", "id: 3")
+	query("id: 3")
+	fresh("retry: 2000")
+
+	send(h, key, "DELETE", "/items/hats", "")
+	for _, expect := range []func(...string){header, query, fresh} {
+		expect("id: 4", "event: delete")
+	}
+
+	if code, body := send(h, key, "GET", "/events", "", "Last-Event-ID", "x"); code != http.StatusBadRequest {
+		t.Errorf("bad Last-Event-ID: %d %s; want %d", code, body, http.StatusBadRequest)
+	}
+}
+
+// TestEventsHead checks that HEAD gets the stream's headers and returns
+func TestEventsHead(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+	req, _ := http.NewRequest("HEAD", ts.URL+"/events", nil)
+	req.Header.Set("Authorization", "Bearer "+key)
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("HEAD /events: %s %q", resp.Status, resp.Header.Get("Content-Type"))
+	}
+}
@@ -0,0 +1,341 @@
+/* Audit log and point-in-time history.
+Every create, update and delete made through writeTx is appended to the
+history bucket with the actor, time and old/new records.
+GET /items/{name}/history  changes to one item, oldest first
+GET /history               latest changes, newest first (?limit=100&before=seq)
+GET /history?at=RFC3339    the whole inventory as it was at the given time */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// historyBucket holds sequence -> change records; it is only appended to
+var historyBucket = []byte("history")
+
+// historyIndexBucket holds item name + 0x00 + sequence -> nil, so the
+// changes to one item can be found without scanning the whole history
+var historyIndexBucket = []byte("history_index")
+
+// Change operations
+const (
+	opCreate = "create"
+	opUpdate = "update"
+	opDelete = "delete"
+)
+
+// Limits for history listings
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+	maxActorLen         = 64
+)
+
+// change is one entry of the append-only history
+type change struct {
+	Seq   uint64    `json:"seq"`
+	Time  time.Time `json:"time"`
+	Actor string    `json:"actor"`
+	Op    string    `json:"op"`
+	Item  string    `json:"item"`
+	Old   *item     `json:"old,omitempty"` // nil for create
+	New   *item     `json:"new,omitempty"` // nil for delete
+}
+
+// writeTx applies item changes inside one read/write transaction and
+// records each of them in the history. All item writes go through it.
+type writeTx struct {
+	tx      StoreTx
+	actor   string
+	now     time.Time
+	changes []change // appended to history, in order
+}
+
+// write runs fn in a read/write transaction on behalf of actor, then
+// hands the committed changes to committed
+func (s *server) write(actor string, fn func(w *writeTx) error) error {
+	var w *writeTx
+	err := s.store.Tx(true, func(tx StoreTx) error {
+		w = &writeTx{tx: tx, actor: actor, now: time.Now().UTC()}
+		return fn(w)
+	})
+	if err == nil && len(w.changes) > 0 {
+		s.committed(w.changes)
+	}
+	return err
+}
+
+// committed updates the search index (see search.go), wakes the event feed
+// and queues any low-stock alerts (see webhook.go) once changes are
+// committed
+func (s *server) committed(changes []change) {
+	s.names.apply(changes)
+	s.feed.notify()
+	if s.lowStock != nil {
+		s.lowStock.lowStockAlerts(changes)
+	}
+}
+
+// get reads the record for name
+func (w *writeTx) get(name string) (item, bool, error) {
+	return w.tx.Get(name)
+}
+
+// put validates and stores it, stamping Created, Updated and Version
+func (w *writeTx) put(it item) error {
+	old, ok, err := w.get(it.Name)
+	if err != nil {
+		return err
+	}
+	if it.Created.IsZero() {
+		it.Created = w.now
+	}
+	it.Updated = w.now
+	it.Version = old.Version + 1 // old is the zero item when creating
+	if err := it.normalize(); err != nil {
+		return err
+	}
+	if err := it.validate(); err != nil {
+		return err
+	}
+	if err := w.tx.Put(it); err != nil {
+		return fmt.Errorf("could not update; try again\n%v", err)
+	}
+	c := change{Op: opCreate, Item: it.Name, New: &it}
+	if ok {
+		c.Op, c.Old = opUpdate, &old
+	}
+	return w.record(c)
+}
+
+// delete removes the record for name
+func (w *writeTx) delete(name string) error {
+	old, ok, err := w.get(name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errNoSuchItem
+	}
+	if err := w.tx.Delete(name); err != nil {
+		return fmt.Errorf("could not delete; try again\n%v", err)
+	}
+	return w.record(change{Op: opDelete, Item: name, Old: &old})
+}
+
+// record appends c to the history and its index
+func (w *writeTx) record(c change) error {
+	b := w.tx.Bucket(historyBucket)
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	c.Seq, c.Time, c.Actor = seq, w.now, w.actor
+	v, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := b.Put(Uint64ToBytes(seq), v); err != nil {
+		return err
+	}
+	if err := w.tx.Bucket(historyIndexBucket).Put(historyIndexKey(c.Item, seq), nil); err != nil {
+		return err
+	}
+	w.changes = append(w.changes, c)
+	return nil
+}
+
+// historyIndexKey returns the index key of change seq to name
+func historyIndexKey(name string, seq uint64) []byte {
+	return append(append([]byte(name), 0), Uint64ToBytes(seq)...)
+}
+
+// decodeChange deserializes a history record
+func decodeChange(v []byte) (change, error) {
+	var c change
+	err := json.Unmarshal(v, &c)
+	return c, err
+}
+
+// actorOf names who made req: the API key that authenticated it, else
+// the X-Actor header if set, else the client's address
+func actorOf(req *http.Request) string {
+	if k, ok := keyOf(req); ok {
+		return k.label()
+	}
+	if a := strings.TrimSpace(req.Header.Get("X-Actor")); a != "" {
+		if len(a) > maxActorLen {
+			a = a[:maxActorLen]
+		}
+		return a
+	}
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
+
+// List changes to one item: GET /items/{name}/history
+func (s *server) itemHistory(w http.ResponseWriter, req *http.Request) {
+	name := itemName(req)
+	list := []change{}
+	err := s.store.Tx(false, func(tx StoreTx) error {
+		h := tx.Bucket(historyBucket)
+		prefix := append([]byte(name), 0)
+		c := tx.Bucket(historyIndexBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			ch, err := decodeChange(h.Get(k[len(prefix):]))
+			if err != nil {
+				return err
+			}
+			list = append(list, ch)
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "could not read history: %v", err)
+		return
+	}
+	if len(list) == 0 {
+		if _, ok, _ := s.lookup(name); !ok {
+			writeError(w, http.StatusNotFound, "no such item: %q", name)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Changes []change `json:"changes"`
+	}{list})
+}
+
+// List recent changes or reconstruct the inventory: GET /history
+func (s *server) history(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+
+	if at := q.Get("at"); at != "" {
+		t, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "at must be an RFC 3339 time: %v", err)
+			return
+		}
+		items, err := s.inventoryAt(t)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "could not read history: %v", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, struct {
+			At    time.Time `json:"at"`
+			Items []item    `json:"items"`
+		}{t.UTC(), items})
+		return
+	}
+
+	limit, err := intParam(q.Get("limit"), defaultHistoryLimit, 1, maxHistoryLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "limit: %v", err)
+		return
+	}
+	var before uint64
+	if b := q.Get("before"); b != "" {
+		if before, err = strconv.ParseUint(b, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, "before must be a sequence number")
+			return
+		}
+	}
+	var list []change
+	err = s.store.Tx(false, func(tx StoreTx) (err error) {
+		list, err = recentChanges(tx, before, limit)
+		return err
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "could not read history: %v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Changes []change `json:"changes"`
+	}{list})
+}
+
+// recentChanges reads up to limit changes, newest first, starting below
+// sequence before, or at the newest change if before is 0
+func recentChanges(tx StoreTx, before uint64, limit int) ([]change, error) {
+	list := []change{}
+	c := tx.Bucket(historyBucket).Cursor()
+	k, v := c.Last()
+	if before > 0 {
+		// position on the last change with seq < before
+		if k, v = c.Seek(Uint64ToBytes(before)); k != nil {
+			k, v = c.Prev()
+		} else {
+			k, v = c.Last()
+		}
+	}
+	for ; k != nil && len(list) < limit; k, v = c.Prev() {
+		ch, err := decodeChange(v)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, ch)
+	}
+	return list, nil
+}
+
+// inventoryAt reconstructs the inventory as it was at t by undoing, newest
+// first, every change made after t. Items that predate the history are
+// taken as they are now.
+func (s *server) inventoryAt(t time.Time) ([]item, error) {
+	items := []item{}
+	err := s.store.Tx(false, func(tx StoreTx) error {
+		current, err := tx.List()
+		if err != nil {
+			return err
+		}
+		state := make(map[string]item, len(current))
+		for _, it := range current {
+			state[it.Name] = it
+		}
+
+		c := tx.Bucket(historyBucket).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			ch, err := decodeChange(v)
+			if err != nil {
+				return err
+			}
+			if !ch.Time.After(t) {
+				break
+			}
+			if ch.Old == nil {
+				delete(state, ch.Item)
+			} else {
+				state[ch.Item] = *ch.Old
+			}
+		}
+
+		// sort by name, the order of the inventory bucket
+		for name := range state {
+			items = append(items, state[name])
+		}
+		sortItems(items)
+		return nil
+	})
+	return items, err
+}
+
+// intParam parses an optional integer query parameter within [min, max]
+func intParam(s string, def, min, max int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("must be an integer between %d and %d", min, max)
+	}
+	return n, nil
+}
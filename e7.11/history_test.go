@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestHistory checks that every write is recorded with the key that made
+// it and the old and new records, per item and overall, and that the
+// inventory can be rebuilt as of a past time
+func TestHistory(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	h := s.handler()
+	k, till, err := s.issueKey("till-1", roleEditor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct{ method, target, body string }{
+		{"PUT", "/items/hats", `{"price": 1}`},
+		{"PUT", "/items/hatstand", `{"price": 20}`},
+		{"PUT", "/items/hats", `{"price": 2}`},
+		{"POST", "/items/hats/movements", `{"kind": "in", "quantity": 3}`},
+		{"DELETE", "/items/hats", ""},
+	} {
+		time.Sleep(time.Millisecond) // distinct times for the ?at= check
+		if code, body := send(h, till, c.method, c.target, c.body); code >= 300 {
+			t.Fatalf("%s %s: %d %s", c.method, c.target, code, body)
+		}
+	}
+
+	get := func(target string, v interface{}) {
+		t.Helper()
+		code, body := send(h, key, "GET", target, "")
+		if code != http.StatusOK || json.Unmarshal([]byte(body), v) != nil {
+			t.Fatalf("GET %s: %d %s", target, code, body)
+		}
+	}
+	var res struct{ Changes []change }
+	get("/items/hats/history", &res)
+	if len(res.Changes) != 4 {
+		t.Fatalf("history of hats: %+v; want 4 changes", res.Changes)
+	}
+	for i, want := range []struct {
+		seq      uint64
+		op       string
+		old, new dollars // -1 for no record
+	}{
+		{1, opCreate, -1, 100},
+		{3, opUpdate, 100, 200},
+		{4, opUpdate, 200, 200},
+		{5, opDelete, 200, -1},
+	} {
+		c := res.Changes[i]
+		price := func(it *item) dollars {
+			if it == nil {
+				return -1
+			}
+			return it.Price
+		}
+		if c.Seq != want.seq || c.Op != want.op || c.Item != "hats" || c.Actor != k.label() || price(c.Old) != want.old || price(c.New) != want.new {
+			t.Errorf("change %d: %+v; want seq %d, %s from %d to %d", i, c, want.seq, want.op, want.old, want.new)
+		}
+	}
+	if c := res.Changes[2]; c.New.Quantity != 3 {
+		t.Errorf("the movement was recorded as %+v", c)
+	}
+	get("/items/hatstand/history", &res)
+	if len(res.Changes) != 1 || res.Changes[0].Seq != 2 {
+		t.Errorf("history of hatstand: %+v; want change 2 only", res.Changes)
+	}
+	if code, _ := send(h, key, "GET", "/items/gloves/history", ""); code != http.StatusNotFound {
+		t.Errorf("history of an unknown item: %d; want %d", code, http.StatusNotFound)
+	}
+
+	get("/history?limit=2", &res)
+	if len(res.Changes) != 2 || res.Changes[0].Seq != 5 || res.Changes[1].Seq != 4 {
+		t.Errorf("latest 2 changes: %+v; want 5 and 4", res.Changes)
+	}
+	get("/history?before=3", &res)
+	if len(res.Changes) != 2 || res.Changes[0].Seq != 2 || res.Changes[1].Seq != 1 {
+		t.Errorf("changes before 3: %+v; want 2 and 1", res.Changes)
+	}
+
+	get("/history?limit=1000", &res)
+	at := res.Changes[2].Time // after change 3: hats at $2 with no stock
+	var past struct{ Items []item }
+	get("/history?at="+at.Format(time.RFC3339Nano), &past)
+	if len(past.Items) != 2 || past.Items[0].Name != "hats" || past.Items[0].Price != 200 || past.Items[0].Quantity != 0 || past.Items[1].Name != "hatstand" {
+		t.Errorf("inventory at %s: %+v", at, past.Items)
+	}
+	get("/history?at=2000-01-01T00:00:00Z", &past)
+	if len(past.Items) != 0 {
+		t.Errorf("inventory in 2000: %+v; want none", past.Items)
+	}
+
+	for _, target := range []string{"/history?limit=0", "/history?before=x", "/history?at=yesterday"} {
+		if code, _ := send(h, key, "GET", target, ""); code != http.StatusBadRequest {
+			t.Errorf("GET %s: %d; want %d", target, code, http.StatusBadRequest)
+		}
+	}
+}
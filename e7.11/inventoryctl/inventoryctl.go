@@ -0,0 +1,830 @@
+/* inventoryctl is a command-line client for item_server (see ../item_server.go).
+Usage: inventoryctl [-url URL] [-key KEY] [-o table|json|csv] COMMAND [ARGS]
+	list [-prefix P] [-q S] [-min N] [-max N] [-category C] [-tag T] [-sort name|price] [-order asc|desc] [-limit N]
+	get NAME
+	set NAME FIELD=VALUE...   fields: price, quantity, reorder_level, sku, description, unit, category, tags (a;b)
+	delete NAME...
+	import [-format csv|ndjson] [-mode upsert|replace] FILE
+	export [-format csv|ndjson] [-prefix P] [-q S] [-category C] [-tag T] [FILE]
+	history [-limit N] [-before SEQ] [NAME]
+	backup [FILE]
+The server URL and API key come from the flags, else $INVENTORY_URL and
+$INVENTORY_KEY, else the config file ($INVENTORY_CONFIG or -config;
+default inventoryctl/config.json in the user config directory, e.g.
+~/.config on Linux):
+	{"url": "https://localhost:8443", "key": "<id>.<secret>", "ca_cert": "cert.pem"}
+ca_cert (or -ca, or $INVENTORY_CA_CERT) is a PEM certificate to trust, such
+as a server's self-signed one (see ../tls.go); a relative path in the file
+is relative to the file. "-" as FILE is standard input or output. Output
+of list -o csv has the columns import takes, so it can be edited and
+imported again; export and backup write the server's own formats.
+Ex ("inventoryctl set shirts price=15 quantity=3 tags='sale;summer'")
+Ex ("inventoryctl -o csv list -category clothing > clothing.csv")
+Ex ("inventoryctl import -mode upsert clothing.csv") */
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// defaultURL is the server used when none is configured
+const defaultURL = "http://localhost:8000"
+
+// pageSize is the number of items fetched per request by list
+const pageSize = 500
+
+// settings tell the client where the server is and how to sign in
+type settings struct {
+	URL    string `json:"url"`
+	Key    string `json:"key"`
+	CACert string `json:"ca_cert"`
+}
+
+// client sends requests to the server's API and prints the results
+type client struct {
+	base   string // server URL without a trailing "/"
+	key    string
+	http   *http.Client
+	output string // table, json or csv
+	out    io.Writer
+}
+
+// command is a subcommand of inventoryctl
+type command struct {
+	name, args string
+	run        func(ctx context.Context, c *client, args []string) error
+}
+
+// commands are listed in the order usage shows them
+var commands = []command{
+	{"list", "[-prefix P] [-q S] [-min N] [-max N] [-category C] [-tag T] [-sort name|price] [-order asc|desc] [-limit N]", list},
+	{"get", "NAME", get},
+	{"set", "NAME FIELD=VALUE...", set},
+	{"delete", "NAME...", del},
+	{"import", "[-format csv|ndjson] [-mode upsert|replace] FILE", importItems},
+	{"export", "[-format csv|ndjson] [-prefix P] [-q S] [-category C] [-tag T] [FILE]", export},
+	{"history", "[-limit N] [-before SEQ] [NAME]", history},
+	{"backup", "[FILE]", backup},
+}
+
+// usageError reports a command used wrongly; main exits with status 2
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("inventoryctl: ")
+
+	fs := flag.NewFlagSet("inventoryctl", flag.ExitOnError)
+	var flags settings
+	fs.StringVar(&flags.URL, "url", "", "server URL; default $INVENTORY_URL, then the config file, then "+defaultURL)
+	fs.StringVar(&flags.Key, "key", "", "API key; default $INVENTORY_KEY, then the config file")
+	fs.StringVar(&flags.CACert, "ca", "", "PEM certificate to trust, e.g. a self-signed server's; default $INVENTORY_CA_CERT")
+	config := fs.String("config", "", "config file; default $INVENTORY_CONFIG, then inventoryctl/config.json in the user config directory")
+	output := fs.String("o", "table", "output format: table, json or csv")
+	timeout := fs.Duration("timeout", 0, "give up on a command after this long; 0 for no limit")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: inventoryctl [flags] COMMAND [ARGS]\ncommands:\n")
+		for _, cmd := range commands {
+			fmt.Fprintf(fs.Output(), "  %s %s\n", cmd.name, cmd.args)
+		}
+		fmt.Fprintf(fs.Output(), "flags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(os.Args[1:])
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == fs.Arg(0) {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		if fs.NArg() > 0 {
+			log.Printf("unknown command %q", fs.Arg(0))
+		}
+		fs.Usage()
+		os.Exit(2)
+	}
+	switch *output {
+	case "table", "json", "csv":
+	default:
+		log.Print("-o must be table, json or csv")
+		os.Exit(2)
+	}
+
+	cfg, err := loadSettings(flags, *config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	c, err := newClient(cfg, *output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	err = cmd.run(ctx, c, fs.Args()[1:])
+	stop()
+	if err != nil {
+		if err.Error() != "" {
+			log.Print(err)
+		}
+		if _, ok := err.(usageError); ok {
+			fmt.Fprintf(os.Stderr, "usage: inventoryctl %s %s\n", cmd.name, cmd.args)
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+// loadSettings combines the settings of the config file, the environment
+// and the flags, later ones overriding earlier ones. A missing config file
+// is only an error if it was named explicitly.
+func loadSettings(flags settings, path string) (settings, error) {
+	set := settings{URL: defaultURL}
+	explicit := true
+	if path == "" {
+		path = os.Getenv("INVENTORY_CONFIG")
+	}
+	if path == "" {
+		explicit = false
+		if dir, err := os.UserConfigDir(); err == nil {
+			path = filepath.Join(dir, "inventoryctl", "config.json")
+		}
+	}
+	if path != "" {
+		if err := set.load(path); err != nil && (explicit || !os.IsNotExist(err)) {
+			return set, err
+		}
+	}
+	set.override(settings{URL: os.Getenv("INVENTORY_URL"), Key: os.Getenv("INVENTORY_KEY"), CACert: os.Getenv("INVENTORY_CA_CERT")})
+	set.override(flags)
+	if set.Key == "" {
+		return set, fmt.Errorf("no API key: use -key, $INVENTORY_KEY or \"key\" in %s", path)
+	}
+	return set, nil
+}
+
+// load reads settings from the JSON file at path, leaving those it does
+// not mention unchanged
+func (s *settings) load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var file settings
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return fmt.Errorf("config %s: %v", path, err)
+	}
+	if file.CACert != "" && !filepath.IsAbs(file.CACert) {
+		file.CACert = filepath.Join(filepath.Dir(path), file.CACert)
+	}
+	s.override(file)
+	return nil
+}
+
+// override replaces the settings of s that are set in o
+func (s *settings) override(o settings) {
+	if o.URL != "" {
+		s.URL = o.URL
+	}
+	if o.Key != "" {
+		s.Key = o.Key
+	}
+	if o.CACert != "" {
+		s.CACert = o.CACert
+	}
+}
+
+// newClient returns a client for the server described by set
+func newClient(set settings, output string) (*client, error) {
+	u, err := url.Parse(set.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("server URL %q must be http://host[:port] or https://host[:port]", set.URL)
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	if set.CACert != "" {
+		pem, err := os.ReadFile(set.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA certificate: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no PEM certificates found", set.CACert)
+		}
+		tr.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &client{
+		base:   strings.TrimSuffix(u.String(), "/"),
+		key:    set.Key,
+		http:   &http.Client{Transport: tr},
+		output: output,
+		out:    os.Stdout,
+	}, nil
+}
+
+// apiError is an error response from the server
+type apiError struct {
+	Status  int    `json:"status"`
+	Message string `json:"error"`
+	Rows    []struct {
+		Line    int    `json:"line"`
+		Message string `json:"error"`
+	} `json:"rows"` // problems with an import, by line
+}
+
+func (e *apiError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "server: %d %s", e.Status, e.Message)
+	for _, r := range e.Rows {
+		fmt.Fprintf(&b, "\n\tline %d: %s", r.Line, r.Message)
+	}
+	return b.String()
+}
+
+// do sends a request to path, which must already be escaped, and returns
+// the response if it succeeded. Otherwise the server's error is returned
+// and the response closed.
+func (c *client) do(ctx context.Context, method, path string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	u := c.base + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.key)
+	req.Header.Set("User-Agent", "inventoryctl")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	e := &apiError{}
+	if json.Unmarshal(b, e) != nil || e.Message == "" {
+		e.Message = strings.TrimSpace(string(b))
+		if e.Message == "" {
+			e.Message = http.StatusText(resp.StatusCode)
+		}
+	}
+	e.Status = resp.StatusCode
+	return nil, e
+}
+
+// call sends in, if not nil, as JSON and decodes the response into out,
+// if not nil
+func (c *client) call(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	var body io.Reader
+	contentType := ""
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body, contentType = strings.NewReader(string(b)), "application/json"
+	}
+	resp, err := c.do(ctx, method, path, query, body, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("reading response: %v", err)
+	}
+	return nil
+}
+
+// itemPath returns the escaped path of item name
+func itemPath(name string) string {
+	return "/items/" + url.PathEscape(name)
+}
+
+// item is an inventory record as the server sends it
+type item struct {
+	Name        string      `json:"name"`
+	SKU         string      `json:"sku"`
+	Description string      `json:"description"`
+	Price       json.Number `json:"price"` // exact decimal, e.g. 19.99
+	Quantity    int64       `json:"quantity"`
+	Reorder     int64       `json:"reorder_level,omitempty"`
+	Unit        string      `json:"unit"`
+	Category    string      `json:"category,omitempty"`
+	Tags        []string    `json:"tags,omitempty"`
+	Created     time.Time   `json:"created"`
+	Updated     time.Time   `json:"updated"`
+	Version     uint64      `json:"version"`
+}
+
+// change is an entry of the server's history
+type change struct {
+	Seq   uint64    `json:"seq"`
+	Time  time.Time `json:"time"`
+	Actor string    `json:"actor"`
+	Op    string    `json:"op"`
+	Item  string    `json:"item"`
+	Old   *item     `json:"old,omitempty"`
+	New   *item     `json:"new,omitempty"`
+}
+
+// filters are the list parameters shared by list and export; see ../list.go
+type filters struct {
+	prefix, q, min, max, category, tag string
+}
+
+// register defines a flag for each filter on fs; export has no price range
+func (f *filters) register(fs *flag.FlagSet, prices bool) {
+	fs.StringVar(&f.prefix, "prefix", "", "names starting with this")
+	fs.StringVar(&f.q, "q", "", "names containing this")
+	if prices {
+		fs.StringVar(&f.min, "min", "", "lowest price")
+		fs.StringVar(&f.max, "max", "", "highest price")
+	}
+	fs.StringVar(&f.category, "category", "", "items in this category or its subcategories")
+	fs.StringVar(&f.tag, "tag", "", "items with this tag")
+}
+
+// query returns the filters that are set as query parameters
+func (f filters) query() url.Values {
+	q := url.Values{}
+	for _, p := range []struct{ name, value string }{
+		{"prefix", f.prefix}, {"q", f.q}, {"min", f.min}, {"max", f.max},
+		{"category", f.category}, {"tag", f.tag},
+	} {
+		if p.value != "" {
+			q.Set(p.name, p.value)
+		}
+	}
+	return q
+}
+
+// newFlagSet returns a flag set for a subcommand. On bad flags or -h it
+// describes its flags and parseFlags returns a usageError, so that main
+// can add the command's usage line before exiting.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "flags of %s:\n", name)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses args with fs and checks the number of arguments left
+func parseFlags(fs *flag.FlagSet, args []string, min, max int) error {
+	if err := fs.Parse(args); err != nil {
+		return usageError("") // already reported by fs
+	}
+	if n := fs.NArg(); n < min || (max >= 0 && n > max) {
+		return usageError("wrong number of arguments")
+	}
+	return nil
+}
+
+// List items: list
+func list(ctx context.Context, c *client, args []string) error {
+	fs := newFlagSet("list")
+	var f filters
+	f.register(fs, true)
+	sort := fs.String("sort", "", "name or price")
+	order := fs.String("order", "", "asc or desc")
+	limit := fs.Int("limit", 0, "at most this many items; 0 for all")
+	if err := parseFlags(fs, args, 0, 0); err != nil {
+		return err
+	}
+	if *limit < 0 {
+		return usageError("-limit must not be negative")
+	}
+	q := f.query()
+	if *sort != "" {
+		q.Set("sort", *sort)
+	}
+	if *order != "" {
+		q.Set("order", *order)
+	}
+	items := []item{}
+	for {
+		n := pageSize
+		if *limit > 0 && *limit-len(items) < n {
+			n = *limit - len(items)
+		}
+		q.Set("limit", strconv.Itoa(n))
+		var page struct {
+			Items      []item `json:"items"`
+			NextCursor string `json:"next_cursor"`
+		}
+		if err := c.call(ctx, "GET", "/items", q, nil, &page); err != nil {
+			return err
+		}
+		items = append(items, page.Items...)
+		if page.NextCursor == "" || (*limit > 0 && len(items) >= *limit) {
+			break
+		}
+		q.Set("cursor", page.NextCursor)
+	}
+	return c.printItems(items, items)
+}
+
+// Show one item: get NAME
+func get(ctx context.Context, c *client, args []string) error {
+	if len(args) != 1 {
+		return usageError("wrong number of arguments")
+	}
+	var it item
+	if err := c.call(ctx, "GET", itemPath(args[0]), nil, nil, &it); err != nil {
+		return err
+	}
+	return c.printItems([]item{it}, it)
+}
+
+// Create or update an item: set NAME FIELD=VALUE...
+func set(ctx context.Context, c *client, args []string) error {
+	if len(args) < 2 {
+		return usageError("wrong number of arguments")
+	}
+	in := make(map[string]interface{})
+	for _, arg := range args[1:] {
+		field, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return usageError(fmt.Sprintf("%q is not FIELD=VALUE", arg))
+		}
+		switch field {
+		case "price":
+			n, err := parsePrice(value)
+			if err != nil {
+				return usageError(err.Error())
+			}
+			in[field] = n
+		case "quantity", "reorder_level":
+			n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				return usageError(fmt.Sprintf("%s must be a whole number", field))
+			}
+			in[field] = n
+		case "tags":
+			tags := []string{}
+			for _, t := range strings.Split(value, ";") {
+				if t = strings.TrimSpace(t); t != "" {
+					tags = append(tags, t)
+				}
+			}
+			in[field] = tags
+		case "sku", "description", "unit", "category":
+			in[field] = value
+		default:
+			return usageError(fmt.Sprintf("unknown field %q; fields are price, quantity, reorder_level, sku, description, unit, category and tags", field))
+		}
+	}
+	var it item
+	if err := c.call(ctx, "PUT", itemPath(args[0]), nil, in, &it); err != nil {
+		return err
+	}
+	return c.printItems([]item{it}, it)
+}
+
+// parsePrice checks a price as the server does (see ../dollars.go): a
+// plain decimal number with at most two decimal places, with or without
+// "$". It is sent in cents-exact form, e.g. ".5" as 0.50, so that no
+// precision is lost on the way.
+func parsePrice(s string) (json.Number, error) {
+	d := strings.TrimPrefix(strings.TrimSpace(s), "$")
+	sign := ""
+	if strings.HasPrefix(d, "-") {
+		sign, d = "-", d[1:]
+	}
+	whole, frac, _ := strings.Cut(d, ".")
+	if whole == "" && frac == "" || len(frac) > 2 || !digits(whole) || !digits(frac) {
+		return "", fmt.Errorf("price %q must be a number with at most two decimal places", s)
+	}
+	w, err := strconv.ParseInt("0"+whole, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("price %q is too large", s)
+	}
+	c, _ := strconv.Atoi((frac + "00")[:2])
+	return json.Number(fmt.Sprintf("%s%d.%02d", sign, w, c)), nil
+}
+
+// digits reports whether s consists only of ASCII digits
+func digits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Delete items: delete NAME...
+func del(ctx context.Context, c *client, args []string) error {
+	if len(args) == 0 {
+		return usageError("wrong number of arguments")
+	}
+	for _, name := range args {
+		if err := c.call(ctx, "DELETE", itemPath(name), nil, nil, nil); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Load items from a file: import FILE
+func importItems(ctx context.Context, c *client, args []string) error {
+	fs := newFlagSet("import")
+	format := fs.String("format", "", "csv or ndjson; default from the file name, else csv")
+	mode := fs.String("mode", "upsert", "upsert, or replace to also delete every item not in the file")
+	if err := parseFlags(fs, args, 1, 1); err != nil {
+		return err
+	}
+	path := fs.Arg(0)
+	if *format == "" {
+		*format = formatOf(path)
+	}
+	contentType := "text/csv"
+	if *format == "ndjson" {
+		contentType = "application/x-ndjson"
+	}
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	resp, err := c.do(ctx, "POST", "/import", url.Values{"format": {*format}, "mode": {*mode}}, r, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var res struct {
+		Mode      string `json:"mode"`
+		Created   int    `json:"created"`
+		Updated   int    `json:"updated"`
+		Unchanged int    `json:"unchanged"`
+		Deleted   int    `json:"deleted"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return fmt.Errorf("reading response: %v", err)
+	}
+	header := []string{"mode", "created", "updated", "unchanged", "deleted"}
+	row := []string{res.Mode, strconv.Itoa(res.Created), strconv.Itoa(res.Updated), strconv.Itoa(res.Unchanged), strconv.Itoa(res.Deleted)}
+	return c.print(res, header, [][]string{row})
+}
+
+// Save the inventory to a file: export [FILE]
+func export(ctx context.Context, c *client, args []string) error {
+	fs := newFlagSet("export")
+	var f filters
+	f.register(fs, false)
+	format := fs.String("format", "", "csv or ndjson; default from the file name, else csv")
+	if err := parseFlags(fs, args, 0, 1); err != nil {
+		return err
+	}
+	path := fs.Arg(0)
+	if path == "" {
+		path = "-"
+	}
+	if *format == "" {
+		*format = formatOf(path)
+	}
+	q := f.query()
+	q.Set("format", *format)
+	resp, err := c.do(ctx, "GET", "/export", q, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if path == "-" {
+		_, err = io.Copy(c.out, resp.Body)
+		return err
+	}
+	_, err = saveTo(path, resp.Body, resp.ContentLength)
+	return err
+}
+
+// Show the history of the inventory or an item: history [NAME]
+func history(ctx context.Context, c *client, args []string) error {
+	fs := newFlagSet("history")
+	limit := fs.Int("limit", 0, "show at most this many of the latest changes; the server defaults to 100")
+	before := fs.Uint64("before", 0, "show changes before this sequence number")
+	if err := parseFlags(fs, args, 0, 1); err != nil {
+		return err
+	}
+	var res struct {
+		Changes []change `json:"changes"`
+	}
+	if name := fs.Arg(0); name != "" {
+		if *limit != 0 || *before != 0 {
+			return usageError("-limit and -before apply to the whole history, not to an item's")
+		}
+		if err := c.call(ctx, "GET", itemPath(name)+"/history", nil, nil, &res); err != nil {
+			return err
+		}
+	} else {
+		q := url.Values{}
+		if *limit != 0 {
+			q.Set("limit", strconv.Itoa(*limit))
+		}
+		if *before != 0 {
+			q.Set("before", strconv.FormatUint(*before, 10))
+		}
+		if err := c.call(ctx, "GET", "/history", q, nil, &res); err != nil {
+			return err
+		}
+	}
+	header := []string{"seq", "time", "actor", "op", "item", "change"}
+	var rows [][]string
+	for _, ch := range res.Changes {
+		rows = append(rows, []string{
+			strconv.FormatUint(ch.Seq, 10), ch.Time.UTC().Format(time.RFC3339),
+			ch.Actor, ch.Op, ch.Item, describe(ch),
+		})
+	}
+	return c.print(res.Changes, header, rows)
+}
+
+// Download a snapshot of the database: backup [FILE]
+func backup(ctx context.Context, c *client, args []string) error {
+	if len(args) > 1 {
+		return usageError("wrong number of arguments")
+	}
+	resp, err := c.do(ctx, "GET", "/admin/backup", nil, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	path := "inventory.db"
+	if len(args) == 1 {
+		path = args[0]
+	} else if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		path = filepath.Base(params["filename"]) // never outside the current directory
+	}
+	if path == "-" {
+		_, err = io.Copy(c.out, resp.Body)
+		return err
+	}
+	n, err := saveTo(path, resp.Body, resp.ContentLength)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote %d bytes to %s\n", n, path)
+	return nil
+}
+
+// formatOf guesses the transfer format of the file at path
+func formatOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ndjson", ".jsonl":
+		return "ndjson"
+	}
+	return "csv"
+}
+
+// saveTo copies r to the file at path through a temporary file in the
+// same directory, so that a failed transfer leaves no partial file. If
+// size is not negative, exactly that many bytes must arrive.
+func saveTo(path string, r io.Reader, size int64) (int64, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	if err == nil && size >= 0 && n != size {
+		err = fmt.Errorf("transfer ended after %d of %d bytes", n, size)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return n, err
+	}
+	return n, nil
+}
+
+// describe summarizes a change for the history table
+func describe(ch change) string {
+	switch {
+	case ch.Old == nil && ch.New != nil:
+		return fmt.Sprintf("price %s, quantity %d", ch.New.Price, ch.New.Quantity)
+	case ch.New == nil:
+		return ""
+	}
+	var parts []string
+	if ch.Old.Price != ch.New.Price {
+		parts = append(parts, fmt.Sprintf("price %s -> %s", ch.Old.Price, ch.New.Price))
+	}
+	if ch.Old.Quantity != ch.New.Quantity {
+		parts = append(parts, fmt.Sprintf("quantity %d -> %d", ch.Old.Quantity, ch.New.Quantity))
+	}
+	if ch.Old.Category != ch.New.Category {
+		parts = append(parts, fmt.Sprintf("category %q -> %q", ch.Old.Category, ch.New.Category))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// printItems prints items; v is what is printed as JSON, so that get
+// prints an object and list an array
+func (c *client) printItems(items []item, v interface{}) error {
+	if c.output == "csv" {
+		// the columns import takes (see ../transfer.go)
+		header := []string{"name", "sku", "description", "price", "quantity", "reorder_level", "unit", "category", "tags"}
+		var rows [][]string
+		for _, it := range items {
+			rows = append(rows, []string{
+				it.Name, it.SKU, it.Description, it.Price.String(),
+				strconv.FormatInt(it.Quantity, 10), strconv.FormatInt(it.Reorder, 10),
+				it.Unit, it.Category, strings.Join(it.Tags, ";"),
+			})
+		}
+		return c.print(v, header, rows)
+	}
+	header := []string{"name", "price", "quantity", "reorder", "unit", "category", "tags", "updated"}
+	var rows [][]string
+	for _, it := range items {
+		rows = append(rows, []string{
+			it.Name, it.Price.String(), strconv.FormatInt(it.Quantity, 10),
+			strconv.FormatInt(it.Reorder, 10), it.Unit, it.Category,
+			strings.Join(it.Tags, ";"), it.Updated.Local().Format("2006-01-02 15:04"),
+		})
+	}
+	return c.print(v, header, rows)
+}
+
+// print writes v as indented JSON, or header and rows as a table or CSV,
+// according to the output format
+func (c *client) print(v interface{}, header []string, rows [][]string) error {
+	switch c.output {
+	case "json":
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "csv":
+		w := csv.NewWriter(c.out)
+		w.Write(header)
+		w.WriteAll(rows)
+		return w.Error()
+	}
+	tw := tabwriter.NewWriter(c.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.ToUpper(strings.Join(header, "\t")))
+	for _, row := range rows {
+		for i, cell := range row {
+			// keep the columns aligned whatever the cell holds
+			row[i] = strings.Map(func(r rune) rune {
+				if r == '\t' || r == '\n' || r == '\r' {
+					return ' '
+				}
+				return r
+			}, cell)
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
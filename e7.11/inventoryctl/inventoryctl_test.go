@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeServer answers the item endpoints the commands use, keeping items in
+// memory, and records the requests it was sent
+type fakeServer struct {
+	mu       sync.Mutex
+	items    map[string]item
+	requests []string // method, path and query of each request
+	bodies   []string // body of each PUT
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, req.Method+" "+req.URL.RequestURI())
+	if req.Header.Get("Authorization") != "Bearer test.key" {
+		http.Error(w, `{"status":401,"error":"no API key"}`, http.StatusUnauthorized)
+		return
+	}
+	name := strings.TrimPrefix(req.URL.Path, "/items/")
+	switch {
+	case req.Method == "GET" && req.URL.Path == "/items":
+		var names []string
+		for n := range f.items {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		from, _ := strconv.Atoi(req.URL.Query().Get("cursor"))
+		limit, _ := strconv.Atoi(req.URL.Query().Get("limit"))
+		var page struct {
+			Items      []item `json:"items"`
+			NextCursor string `json:"next_cursor,omitempty"`
+		}
+		for i := from; i < len(names) && len(page.Items) < limit; i++ {
+			page.Items = append(page.Items, f.items[names[i]])
+			if len(page.Items) == limit && i+1 < len(names) {
+				page.NextCursor = strconv.Itoa(i + 1)
+			}
+		}
+		json.NewEncoder(w).Encode(page)
+	case req.Method == "GET" && name != req.URL.Path:
+		it, ok := f.items[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(apiError{Status: http.StatusNotFound, Message: fmt.Sprintf("no such item: %q", name)})
+			return
+		}
+		json.NewEncoder(w).Encode(it)
+	case req.Method == "PUT" && name != req.URL.Path:
+		b, _ := io.ReadAll(req.Body)
+		f.bodies = append(f.bodies, string(b))
+		it := f.items[name]
+		it.Name = name
+		if err := json.Unmarshal(b, &it); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.items[name] = it
+		json.NewEncoder(w).Encode(it)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// startFake returns a fake server holding n items and a client for it
+// printing JSON to out
+func startFake(t *testing.T, n int, out io.Writer) (*fakeServer, *client) {
+	t.Helper()
+	f := &fakeServer{items: make(map[string]item)}
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("item%02d", i)
+		f.items[name] = item{Name: name, Price: "1.00", Unit: "each"}
+	}
+	ts := httptest.NewServer(f)
+	t.Cleanup(ts.Close)
+	c, err := newClient(settings{URL: ts.URL + "/", Key: "test.key"}, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.out = out
+	return f, c
+}
+
+// TestSetPrice checks that set sends prices the server accepts exactly and
+// refuses the ones it would reject without sending anything
+func TestSetPrice(t *testing.T) {
+	for _, c := range []struct {
+		price, sent string // sent is "" if refused
+	}{
+		{"19.99", "19.99"},
+		{"$15", "15.00"},
+		{" .5 ", "0.50"},
+		{"7.", "7.00"},
+		{"007.1", "7.10"},
+		{"-2.25", "-2.25"}, // the server says why not
+		{"1000001", "1000001.00"},
+		{"1e3", ""},
+		{"NaN", ""},
+		{"Inf", ""},
+		{"1.999", ""},
+		{"+5", ""},
+		{"0x10", ""},
+		{"1,50", ""},
+		{"", ""},
+		{"-", ""},
+		{".", ""},
+		{"99999999999999999999", ""},
+	} {
+		f, cl := startFake(t, 0, io.Discard)
+		err := set(context.Background(), cl, []string{"hats", "price=" + c.price, "quantity=3"})
+		if c.sent == "" {
+			if _, ok := err.(usageError); !ok || len(f.requests) != 0 {
+				t.Errorf("set price=%q: %v after %d requests; want a usage error and none", c.price, err, len(f.requests))
+			}
+			continue
+		}
+		if want := `{"price":` + c.sent + `,"quantity":3}`; err != nil || len(f.bodies) != 1 || f.bodies[0] != want {
+			t.Errorf("set price=%q: %v, sent %q; want %s", c.price, err, f.bodies, want)
+		}
+	}
+}
+
+// TestSetFields checks the other fields of set and its usage errors
+func TestSetFields(t *testing.T) {
+	var out bytes.Buffer
+	f, c := startFake(t, 0, &out)
+	err := set(context.Background(), c, []string{"hats", "reorder_level=2", "tags= sale;;summer ", "category=clothing/hats", "description=a=b"})
+	want := `{"category":"clothing/hats","description":"a=b","reorder_level":2,"tags":["sale","summer"]}`
+	if err != nil || len(f.bodies) != 1 || f.bodies[0] != want {
+		t.Fatalf("set: %v, sent %q; want %s", err, f.bodies, want)
+	}
+	var it item
+	if err := json.Unmarshal(out.Bytes(), &it); err != nil || it.Name != "hats" || it.Reorder != 2 {
+		t.Errorf("set printed %s", out.String())
+	}
+	for _, args := range [][]string{{"hats"}, {"hats", "price"}, {"hats", "colour=red"}, {"hats", "quantity=1.5"}} {
+		if _, ok := set(context.Background(), c, args).(usageError); !ok {
+			t.Errorf("set %q: want a usage error", args)
+		}
+	}
+}
+
+// TestList checks that list follows cursors until it has -limit items, or
+// every item with no limit, and refuses a negative limit
+func TestList(t *testing.T) {
+	for _, c := range []struct {
+		args  []string
+		items int
+	}{
+		{nil, 7},
+		{[]string{"-limit", "0"}, 7},
+		{[]string{"-limit", "3"}, 3},
+		{[]string{"-limit", "10"}, 7},
+	} {
+		var out bytes.Buffer
+		_, cl := startFake(t, 7, &out)
+		var items []item
+		if err := list(context.Background(), cl, c.args); err != nil {
+			t.Errorf("list %q: %v", c.args, err)
+		} else if err := json.Unmarshal(out.Bytes(), &items); err != nil || len(items) != c.items {
+			t.Errorf("list %q: %d items (%v); want %d", c.args, len(items), err, c.items)
+		}
+	}
+
+	f, cl := startFake(t, 7, io.Discard)
+	if _, ok := list(context.Background(), cl, []string{"-limit", "-1"}).(usageError); !ok || len(f.requests) != 0 {
+		t.Errorf("list -limit -1: want a usage error and no requests; sent %v", f.requests)
+	}
+}
+
+// TestListPages checks the page requests list makes
+func TestListPages(t *testing.T) {
+	f, c := startFake(t, pageSize+2, io.Discard)
+	if err := list(context.Background(), c, []string{"-prefix", "item", "-limit", fmt.Sprint(pageSize + 1)}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"GET /items?limit=500&prefix=item",
+		"GET /items?cursor=500&limit=1&prefix=item",
+	}
+	if fmt.Sprint(f.requests) != fmt.Sprint(want) {
+		t.Errorf("requests %q; want %q", f.requests, want)
+	}
+}
+
+// TestServerErrors checks that the server's errors reach the user
+func TestServerErrors(t *testing.T) {
+	_, c := startFake(t, 1, io.Discard)
+	err := get(context.Background(), c, []string{"socks"})
+	if e, ok := err.(*apiError); !ok || e.Status != http.StatusNotFound || e.Message != `no such item: "socks"` {
+		t.Errorf("get socks: %#v", err)
+	}
+	c.key = "wrong"
+	if err := get(context.Background(), c, []string{"item00"}); err == nil || err.Error() != "server: 401 no API key" {
+		t.Errorf("get with a wrong key: %v", err)
+	}
+	var out bytes.Buffer
+	c.key, c.out, c.output = "test.key", &out, "csv"
+	if err := get(context.Background(), c, []string{"item00"}); err != nil || !strings.HasPrefix(out.String(), "name,sku,description,price,") || !strings.Contains(out.String(), "item00,,,1.00,0,0,each,,\n") {
+		t.Errorf("get -o csv: %v %q", err, out.String())
+	}
+}
@@ -1,180 +1,446 @@
 /* Create server with handlers to enable clients to
 Create, Read, Update and Delete inventory database entries.
-Ex ("http://localhost:8000/update?item=shirts&price=15") */
+JSON resource API (see api.go):
+Ex ("curl -X PUT -d '{"price": 15}' http://localhost:8000/items/shirts")
+Routes are scoped to their methods; any other method gets 405 (see routes).
+The original text endpoints that change the inventory on GET are only
+served with -legacy:
+Ex ("http://localhost:8000/update?item=shirts&price=15")
+Batches of changes in one transaction (see batch.go):
+Ex ("curl -d '{"ops": [{"op": "delete", "item": "shirts"}]}' http://localhost:8000/batch")
+Future price changes, applied by a background scheduler (see schedule.go):
+Ex ("curl -d '{"price": 9.99, "start": "2024-07-01T00:00:00Z"}' http://localhost:8000/items/shirts/schedules")
+A live feed of changes as Server-Sent Events (see events.go):
+Ex ("curl -N http://localhost:8000/events")
+Search by name prefix, substring or with typos (see search.go):
+Ex ("curl http://localhost:8000/search?q=shrt")
+Valuation, price and low-stock reports (see report.go):
+Ex ("curl http://localhost:8000/reports/low-stock?category=clothing")
+Bulk export and import as CSV or NDJSON (see transfer.go):
+Ex ("curl --data-binary @prices.csv http://localhost:8000/import?mode=upsert")
+Read-only followers replicate a leader (see replication.go):
+Ex ("item_server -addr localhost:8001 -db db/follower.db -follow http://localhost:8000 -leader-key <key>")
+Flags or a JSON config file set the address, database, timeouts and TLS (see config.go):
+Ex ("item_server -config server.json -addr localhost:8443 -self-signed")
+A browser dashboard, signed into with an API key (see dashboard.go):
+Ex ("http://localhost:8000/ui")
+Every other request needs an API key (see auth.go):
+Ex ("curl -H 'Authorization: Bearer <key>' http://localhost:8000/list")
+A command-line client wraps the API (see inventoryctl/inventoryctl.go):
+Ex ("INVENTORY_KEY=<key> inventoryctl list -category clothing") */
 
 package main
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"log"
 	"math"
 	"net/http"
 	"os"
-	"strconv"
-	"strings"
+	"os/signal"
+	"path/filepath"
 	"sync"
-
-	"github.com/boltdb/bolt"
+	"syscall"
+	"time"
 	// "homecook/conv"  // imported functions' source code at bottom
 )
 
-// Lock when creating/updating/deleting db values
-var mutex = &sync.Mutex{}
+// inventoryBucket holds item name -> item records
+var inventoryBucket = []byte("inventory")
+
+// buckets are created when the database is opened
+var buckets = [][]byte{
+	inventoryBucket, priceIndexBucket, categoryIndexBucket, tagIndexBucket,
+	movementsBucket, movementIndexBucket, ordersBucket, historyBucket, historyIndexBucket,
+	apiKeysBucket, schedulesBucket, scheduleDueBucket,
+}
 
 func main() {
-	// create "db" directory if not exists
-	if _, err := os.Stat("db"); os.IsNotExist(err) {
-		os.Mkdir("db", 0755)
+	cfg, err := parseConfig(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	dbMap := make(database) // in memory store
+	// create the database directory if not exists
+	if dir := filepath.Dir(cfg.DB); dir != "" {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			os.MkdirAll(dir, 0755)
+		}
+	}
 
-	// load db into in-memory map
-	db, err := bolt.Open("db/inventory.db", 0755, nil)
+	// offline database stays open for the server's lifetime
+	store, err := openBoltStore(cfg.DB)
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer store.Close()
 
-	// read/write transaction
-	if err := db.Update(func(tx *bolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists([]byte("inventory"))
+	s, err := newServer(store)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// background work stops with the server, before the database closes
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	start := func(run func(context.Context)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run(ctx)
+		}()
+	}
+	if cfg.Follow != "" {
+		r, err := newReplica(s, cfg.Follow, cfg.LeaderKey)
 		if err != nil {
-			return fmt.Errorf("could not load database\n%v", err)
+			log.Fatal(err)
 		}
-		c := b.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			dbMap[string(k)] = dollars(BytesToFloat64(v)) // decode bytes to type dollars
+		start(r.run)
+	} else {
+		if key, err := s.bootstrapAdminKey(); err != nil {
+			log.Fatal(err)
+		} else if key != "" {
+			log.Printf("no admin API key found; issued one (shown only once):\n\t%s", key)
 		}
-		return nil
-	}); err != nil {
-		log.Fatal(err)
+		// the scheduler's changes read s.lowStock, so it is set first
+		if cfg.LowStockWebhook != "" {
+			if s.lowStock, err = newWebhook(cfg.LowStockWebhook); err != nil {
+				log.Fatal(err)
+			}
+			start(s.lowStock.run)
+		}
+		start(s.runScheduler)
 	}
-	db.Close()
 
-	http.HandleFunc("/list", dbMap.list)
-	http.HandleFunc("/price", dbMap.price)
-	http.HandleFunc("/update", dbMap.update)
-	http.HandleFunc("/delete", dbMap.delete)
-	log.Fatal(http.ListenAndServe("localhost:8000", nil))
+	s.legacy = cfg.Legacy
+	srv := cfg.httpServer(s.handler())
+	srv.TLSConfig = tlsConfig
+	srv.RegisterOnShutdown(s.feed.stop)
+	log.Printf("serving on %s (tls: %t)", cfg.Addr, tlsConfig != nil)
+	if err := serve(ctx, srv, time.Duration(cfg.ShutdownTimeout)); err != nil {
+		log.Print(err)
+	}
+	stop() // also if serve failed, so that the deferred Wait returns
 }
 
-/* dollars interface */
-type dollars float32
+// server serves the inventory held in store.
+// Reads run in read-only transactions, which see a consistent snapshot
+// and may run concurrently with each other and with the single writer that
+// the store allows at a time. The only in memory copy of the inventory is
+// the list of names used by search, which has its own lock.
+type server struct {
+	store     InventoryStore
+	feed      *feed         // wakes /events streams after each commit
+	replica   *replica      // set when following a leader; nil on a leader
+	schedWake chan struct{} // wakes the scheduler when schedules change
+	lowStock  *webhook      // receives low-stock alerts; nil if not configured
+	names     *nameIndex    // item names for search, updated on commit
+	legacy    bool          // serve /update and /delete, which change state on GET
+	sessions  *sessionStore // dashboard sign-ins; see dashboard.go
+}
 
-func (d dollars) String() string { return fmt.Sprintf("$%.2f", d) }
+// newServer creates the buckets in store if they do not exist
+func newServer(store InventoryStore) (*server, error) {
+	if err := prepareStore(store); err != nil {
+		return nil, err
+	}
+	names := &nameIndex{}
+	if err := names.load(store); err != nil {
+		return nil, err
+	}
+	return &server{store: store, feed: newFeed(), schedWake: make(chan struct{}, 1), names: names, sessions: newSessionStore()}, nil
+}
+
+// prepareStore creates the buckets in store and migrates its records to
+// the current schema
+func prepareStore(store InventoryStore) error {
+	// read/write transaction
+	return store.Tx(true, func(tx StoreTx) error {
+		for _, name := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return fmt.Errorf("could not load database\n%v", err)
+			}
+		}
+		if err := migrate(tx); err != nil {
+			return err
+		}
+		return setGeneration(tx, false)
+	})
+}
 
-/* database interface */
-type database map[string]dollars
+// route is one endpoint: a method and path pattern for http.ServeMux, the
+// role a key needs to use it (see auth.go) and its handler. A route with
+// no method matches every method; one with no role does its own
+// authentication, as the dashboard does with sessions.
+type route struct {
+	method, path, role string
+	h                  http.HandlerFunc
+}
 
-// List (Read) all items in database
-func (db database) list(w http.ResponseWriter, req *http.Request) {
-	for item, price := range db {
-		fmt.Fprintf(w, "%s: %s\n", item, price.String())
+// routes lists the server's endpoints
+func (s *server) routes() []route {
+	rs := []route{
+		{"GET", "/list", roleReader, s.list},
+		{"GET", "/price", roleReader, s.price},
+		{"GET", "/items", roleReader, s.items},
+		{"POST", "/items", roleEditor, s.createItem},
+		{"GET", "/items/{name}", roleReader, s.getItem},
+		{"PUT", "/items/{name}", roleEditor, s.putItem},
+		{"DELETE", "/items/{name}", roleEditor, s.deleteItem},
+		{"GET", "/items/{name}/movements", roleReader, s.listMovements},
+		{"POST", "/items/{name}/movements", roleEditor, s.addMovement},
+		{"GET", "/items/{name}/history", roleReader, s.itemHistory},
+		{"POST", "/items/{name}/move", roleEditor, s.moveItem},
+		{"GET", "/items/{name}/schedules", roleReader, s.itemSchedules},
+		{"POST", "/items/{name}/schedules", roleEditor, s.addItemSchedule},
+		{"POST", "/orders", roleEditor, s.orders},
+		{"GET", "/categories", roleReader, s.categories},
+		{"GET", "/tags", roleReader, s.tags},
+		{"GET", "/search", roleReader, s.search},
+		{"GET", "/reports/valuation", roleReader, s.valuationReport},
+		{"GET", "/reports/prices", roleReader, s.pricesReport},
+		{"GET", "/reports/low-stock", roleReader, s.lowStockReport},
+		{"GET", "/reports/stale", roleReader, s.staleReport},
+		{"GET", "/schedules", roleReader, s.schedules},
+		{"GET", "/schedules/{id}", roleReader, s.scheduleByID},
+		{"DELETE", "/schedules/{id}", roleEditor, s.deleteSchedule},
+		{"POST", "/batch", roleEditor, s.batch},
+		{"GET", "/export", roleReader, untimed(s.export)},
+		{"POST", "/import", roleEditor, untimed(s.importItems)},
+		{"GET", "/history", roleReader, s.history},
+		{"GET", "/events", roleReader, untimed(s.events)},
+		{"GET", "/admin/keys", roleAdmin, s.adminKeys},
+		{"POST", "/admin/keys", roleAdmin, s.adminIssueKey},
+		{"DELETE", "/admin/keys/{id}", roleAdmin, s.adminRevokeKey},
+		{"GET", "/admin/backup", roleAdmin, untimed(s.backup)},
+		{"POST", "/admin/restore", roleAdmin, untimed(s.restore)},
+		{"POST", "/admin/compact", roleAdmin, untimed(s.compact)},
+		{"GET", "/replication/log", roleAdmin, untimed(s.replicationLog)},
+		{"GET", "/replication/status", roleReader, s.replicationStatus},
+		{"GET", "/ui", "", s.dashboardAuth(roleReader, s.dashboard)},
+		{"GET", "/ui/login", "", s.loginPage},
+		{"POST", "/ui/login", "", s.login},
+		{"POST", "/ui/logout", "", s.dashboardAuth(roleReader, s.logout)},
+		{"POST", "/ui/items", "", s.dashboardAuth(roleEditor, s.dashboardAdd)},
+		{"POST", "/ui/items/{name}/price", "", s.dashboardAuth(roleEditor, s.dashboardPrice)},
+		{"POST", "/ui/items/{name}/delete", "", s.dashboardAuth(roleEditor, s.dashboardDelete)},
+	}
+	if s.legacy {
+		// these change the inventory on GET, so links, crawlers and
+		// prefetchers can change it too; they are only served when asked for
+		rs = append(rs,
+			route{"", "/update", roleEditor, s.update},
+			route{"", "/delete", roleEditor, s.delete})
+	}
+	return rs
+}
+
+// handler routes requests to the server's handlers, enforcing the role
+// each endpoint requires (see auth.go). A request for a known path with a
+// method it does not support gets 405 with an Allow header; one for an
+// unknown path gets 404. A follower only serves reads.
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	allowed := make(map[string][]string)
+	var paths []string
+	for _, r := range s.routes() {
+		pattern := r.path
+		if r.method != "" {
+			pattern = r.method + " " + r.path
+			if allowed[r.path] == nil {
+				paths = append(paths, r.path)
+			}
+			allowed[r.path] = append(allowed[r.path], r.method)
+			if r.method == http.MethodGet {
+				allowed[r.path] = append(allowed[r.path], http.MethodHead) // served by GET patterns
+			}
+		}
+		h := r.h
+		if r.role != "" {
+			h = s.require(r.role, h)
+		}
+		mux.HandleFunc(pattern, h)
+	}
+	for _, p := range paths {
+		methods := allowed[p]
+		mux.HandleFunc(p, func(w http.ResponseWriter, req *http.Request) {
+			methodNotAllowed(w, methods...)
+		})
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		writeError(w, http.StatusNotFound, "not found: %s", req.URL.Path)
+	})
+	if s.replica != nil {
+		return s.readOnly(mux)
+	}
+	return mux
+}
+
+// List (Read) items in database, filtered and paged as described in list.go.
+// The cursor of the next page, if any, is sent in the X-Next-Cursor header.
+func (s *server) list(w http.ResponseWriter, req *http.Request) {
+	lq, err := parseListQuery(req.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest) // 400
+		fmt.Fprintf(w, "error: %v", err)
+		return
+	}
+	items, next, err := s.query(lq)
+	if next != "" {
+		w.Header().Set("X-Next-Cursor", next)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		fmt.Fprintf(w, "error: could not read database\n%v", err)
+		return
+	}
+	for _, it := range items {
+		fmt.Fprintf(w, "%s: %s\n", it.Name, it.Price.String())
 	}
 }
 
 // Read price for specified item
-func (db database) price(w http.ResponseWriter, req *http.Request) {
-	item := req.URL.Query().Get("item")
-	price, ok := db[item]
+func (s *server) price(w http.ResponseWriter, req *http.Request) {
+	name := canonicalName(req.URL.Query().Get("item"))
+	it, ok, err := s.lookup(name)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		fmt.Fprintf(w, "error: could not read database\n%v", err)
+		return
+	}
 	if !ok {
 		w.WriteHeader(http.StatusNotFound) // 404
-		fmt.Fprintf(w, "no such item: %q\n", item)
+		fmt.Fprintf(w, "no such item: %q\n", name)
 		return
 	}
-	fmt.Fprintf(w, "%s\n", price.String())
+	fmt.Fprintf(w, "%s\n", it.Price.String())
 }
 
 // Create new item or Update existing entry
-func (db database) update(w http.ResponseWriter, req *http.Request) {
-	item := strings.ToLower(req.URL.Query().Get("item"))
+func (s *server) update(w http.ResponseWriter, req *http.Request) {
+	name := canonicalName(req.URL.Query().Get("item"))
 	price := req.URL.Query().Get("price")
 	if price == "" {
+		w.WriteHeader(http.StatusBadRequest) // 400
 		fmt.Fprintf(w, "error: price not set")
 		return
 	}
 
-	// convert string from URL to float64 and check value
-	p, err := strconv.ParseFloat(price, 32)
+	// convert string from URL to exact dollars and check value
+	dv, err := parseDollars(price)
 	if err != nil {
-		fmt.Fprintf(w, "error: price must be numerical value")
-		return
-	}
-
-	// convert to dollars and verify price is >= 0
-	dv := dollars(p)
-	if dv < 0 {
-		fmt.Fprintf(w, "error: price must be greater than or equal to 0")
+		w.WriteHeader(http.StatusBadRequest) // 400
+		fmt.Fprintf(w, "error: %v", err)
 		return
 	}
-
-	mutex.Lock()
-	oldb, err := bolt.Open("db/inventory.db", 0755, nil) // offline database
-	if err != nil {
-		fmt.Fprintf(w, "error: offline database could not be opened; try again\n%v", err)
+	if err := checkPrice(dv); err != nil {
+		w.WriteHeader(http.StatusBadRequest) // 400
+		fmt.Fprintf(w, "error: %v", err)
 		return
 	}
 
-	// Create/Update transaction
-	if err := oldb.Update(func(tx *bolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists([]byte("inventory"))
-		if err != nil {
-			return fmt.Errorf("offline database could not be opened; try again\n%v", err)
-		}
-		if err := b.Put([]byte(item), Float64ToBytes((p))); err != nil { // serialize k,v
-			return fmt.Errorf("could not update; try again\n%v", err)
-		}
+	if _, _, err := s.save(actorOf(req), name, func(it *item, created bool) error {
+		it.Price = dv
 		return nil
 	}); err != nil {
+		if _, ok := err.(invalidError); ok {
+			w.WriteHeader(http.StatusBadRequest) // 400
+			fmt.Fprintf(w, "error: %v", err)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError) // 500
 		fmt.Fprintf(w, "error: data store unsuccessful\n%v", err)
 		return
 	}
 
-	oldb.Close()
-	db[item] = dv // store in memory after successful disk storage
-	mutex.Unlock()
-
-	fmt.Fprintf(w, "stored in database: %s: %s\n", item, db[item].String())
+	fmt.Fprintf(w, "stored in database: %s: %s\n", name, dv.String())
 }
 
 // Delete specified entry
-func (db database) delete(w http.ResponseWriter, req *http.Request) {
-	item := strings.ToLower(req.URL.Query().Get("item"))
-	_, ok := db[item]
-	if !ok {
-		w.WriteHeader(http.StatusNotFound) // 404
-		fmt.Fprintf(w, "no such item: %q\n", item)
+func (s *server) delete(w http.ResponseWriter, req *http.Request) {
+	name := canonicalName(req.URL.Query().Get("item"))
+	if err := s.remove(actorOf(req), name, nil); err != nil {
+		if err == errNoSuchItem {
+			w.WriteHeader(http.StatusNotFound) // 404
+			fmt.Fprintf(w, "no such item: %q\n", name)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		fmt.Fprintf(w, "error: deletion unsuccessful\n%v", err)
 		return
 	}
 
-	mutex.Lock()
-	oldb, err := bolt.Open("db/inventory.db", 0755, nil) // offline database
-	if err != nil {
-		fmt.Fprintf(w, "error: offline database could not be opened; try again\n%v", err)
-		return
-	}
+	fmt.Fprintf(w, "item deleted: %s\n", name)
+}
 
-	// Delete transaction
-	if err := oldb.Update(func(tx *bolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists([]byte("inventory"))
-		if err != nil {
-			return fmt.Errorf("offline database could not be opened; try again\n%v", err)
+// errNoSuchItem is returned when deleting an item not in the database
+var errNoSuchItem = errors.New("no such item")
+
+// errItemExists is returned when creating an item that already exists
+var errItemExists = errors.New("item already exists")
+
+// lookup reads the record for name from the database
+func (s *server) lookup(name string) (item, bool, error) {
+	return s.store.Get(name)
+}
+
+// save applies change to the record for name, or to a new record if name
+// does not exist (created is true), and stores the result in one
+// transaction on behalf of actor. It reports the stored record and whether
+// it was created.
+// Shared by the text and JSON handlers.
+func (s *server) save(actor, name string, change func(it *item, created bool) error) (it item, created bool, err error) {
+	// Create/Update transaction
+	err = s.write(actor, func(w *writeTx) error {
+		var ok bool
+		if it, ok, err = w.get(name); err != nil {
+			return err
 		}
-		if err := b.Delete([]byte(item)); err != nil {
-			return fmt.Errorf("could not delete; try again\n%v", err)
+		if created = !ok; created {
+			it = item{Name: name, Unit: defaultUnit}
 		}
-		return nil
-	}); err != nil {
-		fmt.Fprintf(w, "error: deletion unsuccessful\n%v", err)
-		return
-	}
-
-	oldb.Close()
-	delete(db, item) // update in memory after successful deletion from disk
-	mutex.Unlock()
+		if err := change(&it, created); err != nil {
+			return err
+		}
+		it.Name = name
+		if err := w.put(it); err != nil {
+			return err
+		}
+		it, _, err = w.get(name) // read back timestamps
+		return err
+	})
+	return it, created, err
+}
 
-	fmt.Fprintf(w, "item deleted: %s\n", item)
+// remove deletes name from the database on behalf of actor. If check is
+// not nil it is called with the current record first and may veto the
+// deletion by returning an error.
+func (s *server) remove(actor, name string, check func(it item) error) error {
+	// Delete transaction
+	return s.write(actor, func(w *writeTx) error {
+		if check != nil {
+			it, ok, err := w.get(name)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return errNoSuchItem
+			}
+			if err := check(it); err != nil {
+				return err
+			}
+		}
+		return w.delete(name)
+	})
 }
 
 /* "imported" functions from 'homecook/conv' (home-made utilities packages) */
@@ -184,6 +450,13 @@ func BytesToUint64(bs []byte) uint64 {
 	return binary.BigEndian.Uint64(bs)
 }
 
+// Uint64ToBytes encodes a uint64 value to a byte slice
+func Uint64ToBytes(u uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, u)
+	return b
+}
+
 // Float64ToBytes encodes a uint64 value to a byte slice
 func Float64ToBytes(fl float64) []byte {
 	b := make([]byte, 8)
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testStores returns an empty store of each kind, by name
+func testStores(t *testing.T) map[string]InventoryStore {
+	return map[string]InventoryStore{"bolt": openTestBolt(t), "mem": newMemStore()}
+}
+
+// newTestServer returns a server over st that also serves the legacy
+// endpoints, and an admin key for it
+func newTestServer(tb testing.TB, st InventoryStore) (*server, string) {
+	tb.Helper()
+	s, err := newServer(st)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	s.legacy = true
+	key, err := s.bootstrapAdminKey()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return s, key
+}
+
+// send makes a request to h with key and any further headers, given as
+// name, value pairs, and returns the status and body
+func send(h http.Handler, key, method, target, body string, header ...string) (int, string) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+key)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	b, _ := io.ReadAll(rec.Body)
+	return rec.Code, string(b)
+}
+
+// TestReopen checks that writes reach the database file, so that a server
+// started on it later has them
+func TestReopen(t *testing.T) {
+	st := openTestBolt(t)
+	s, key := newTestServer(t, st)
+	h := s.handler()
+	send(h, key, "GET", "/update?item=hats&price=3", "")
+	send(h, key, "GET", "/update?item=socks&price=1.50", "")
+	send(h, key, "GET", "/delete?item=hats", "")
+	path := st.path
+	if err := st.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := openBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	s, _ = newTestServer(t, st) // the admin key is still in the file
+	h = s.handler()
+	if code, body := send(h, key, "GET", "/list", ""); code != http.StatusOK || body != "socks: $1.50\n" {
+		t.Errorf("list after reopening: %d %q", code, body)
+	}
+}
+
+// listLine is a line of /list
+var listLine = regexp.MustCompile(`^[^:]+: \$[0-9]+\.[0-9]{2}$`)
+
+// TestConcurrentHandlers runs list, price, update and delete at once from
+// many goroutines; run it with -race. Each worker also owns an item that
+// only it writes, so it can check that it reads back its own writes.
+func TestConcurrentHandlers(t *testing.T) {
+	for name, st := range testStores(t) {
+		t.Run(name, func(t *testing.T) { testConcurrentHandlers(t, st) })
+	}
+}
+
+func testConcurrentHandlers(t *testing.T, st InventoryStore) {
+	s, key := newTestServer(t, st)
+	h := s.handler()
+
+	const workers, rounds = 8, 40
+	shared := []string{"hats", "shirts", "socks"}
+	var wg sync.WaitGroup
+	for g := 0; g < workers; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			own := fmt.Sprintf("worker %d", g)
+			for i := 0; i < rounds; i++ {
+				price := fmt.Sprintf("%d.%02d", g, i)
+				name := shared[(g+i)%len(shared)]
+
+				if code, body := send(h, key, "GET", "/update?item="+name+"&price="+price, ""); code != http.StatusOK {
+					t.Errorf("update %s: %d %s", name, code, body)
+				}
+				if code, body := send(h, key, "GET", "/price?item="+name, ""); code != http.StatusOK && code != http.StatusNotFound {
+					t.Errorf("price %s: %d %s", name, code, body)
+				}
+				if code, body := send(h, key, "GET", "/delete?item="+name, ""); code != http.StatusOK && code != http.StatusNotFound {
+					t.Errorf("delete %s: %d %s", name, code, body)
+				}
+
+				send(h, key, "GET", "/update?item="+strings.ReplaceAll(own, " ", "%20")+"&price="+price, "")
+				if code, body := send(h, key, "GET", "/price?item="+strings.ReplaceAll(own, " ", "%20"), ""); code != http.StatusOK || body != "$"+price+"\n" {
+					t.Errorf("%s: read back %d %q after writing %s", own, code, body, price)
+				}
+
+				code, body := send(h, key, "GET", "/list", "")
+				if code != http.StatusOK {
+					t.Errorf("list: %d %s", code, body)
+					continue
+				}
+				for _, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+					if line != "" && !listLine.MatchString(line) {
+						t.Errorf("list: malformed line %q", line)
+					}
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	code, body := send(h, key, "GET", "/list", "")
+	if code != http.StatusOK || strings.Count(body, "worker") != workers {
+		t.Errorf("after the workers finished: %d %q; want %d worker items", code, body, workers)
+	}
+	for g := 0; g < workers; g++ {
+		want := fmt.Sprintf("worker %d: $%d.%02d\n", g, g, rounds-1)
+		if !strings.Contains(body, want) {
+			t.Errorf("list lacks %q", want)
+		}
+	}
+}
+
+// TestRouting checks the answers for unsupported methods, unknown paths and
+// HEAD, and that the legacy endpoints are served only when asked for
+func TestRouting(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		t.Run(fmt.Sprintf("legacy=%v", legacy), func(t *testing.T) {
+			s, key := newTestServer(t, newMemStore())
+			s.legacy = legacy
+			h := s.handler()
+			if code, body := send(h, key, "PUT", "/items/hats", `{"price": 1}`); code != http.StatusCreated {
+				t.Fatalf("create: %d %s", code, body)
+			}
+
+			for _, c := range []struct {
+				method, target string
+				code           int
+				allow, body    string
+			}{
+				{"PATCH", "/items/hats", http.StatusMethodNotAllowed, "GET, HEAD, PUT, DELETE", `{"status":405,"error":"method not allowed"}`},
+				{"DELETE", "/items", http.StatusMethodNotAllowed, "GET, HEAD, POST", `"status":405`},
+				{"POST", "/list", http.StatusMethodNotAllowed, "GET, HEAD", `"status":405`},
+				{"GET", "/nowhere", http.StatusNotFound, "", `{"status":404,"error":"not found: /nowhere"}`},
+				{"HEAD", "/items/hats", http.StatusOK, "", ""}, // net/http drops the body
+				{"HEAD", "/list", http.StatusOK, "", ""},
+				{"HEAD", "/items/socks", http.StatusNotFound, "", ""},
+			} {
+				req := httptest.NewRequest(c.method, c.target, nil)
+				req.Header.Set("Authorization", "Bearer "+key)
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				if rec.Code != c.code || rec.Header().Get("Allow") != c.allow || !strings.Contains(rec.Body.String(), c.body) {
+					t.Errorf("%s %s: %d, Allow %q, %q; want %d, Allow %q, %q",
+						c.method, c.target, rec.Code, rec.Header().Get("Allow"), rec.Body.String(), c.code, c.allow, c.body)
+				}
+			}
+
+			update, price := http.StatusNotFound, "$1.00\n"
+			if legacy {
+				update, price = http.StatusOK, "$2.00\n"
+			}
+			if code, body := send(h, key, "GET", "/update?item=hats&price=2", ""); code != update {
+				t.Errorf("/update: %d %s; want %d", code, body, update)
+			}
+			if _, body := send(h, key, "GET", "/price?item=hats", ""); body != price {
+				t.Errorf("price after /update: %q; want %q", body, price)
+			}
+			code, _ := send(h, key, "GET", "/delete?item=hats", "")
+			_, body := send(h, key, "GET", "/list", "")
+			if legacy && (code != http.StatusOK || body != "") || !legacy && (code != http.StatusNotFound || body != "hats: $1.00\n") {
+				t.Errorf("/delete: %d, then list %q", code, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+/* Filtering, sorting and pagination for /list and GET /items.
+Query parameters (all optional):
+	prefix=sh         names starting with "sh"
+	q=irt             names containing "irt"
+	min=5&max=20.50   price range, inclusive
+	category=clothing items in a category or its subcategories
+	tag=sale          items with a tag (see category.go)
+	sort=name|price   default name
+	order=asc|desc    default asc
+	limit=50          page size; without it every match is returned
+	cursor=...        next_cursor from the previous page
+Pages are read with Cursor.Seek on the inventory bucket (sort=name), the
+price index (sort=price) or, when sorting by name within a tag, that tag's
+range of its index; so each page costs the same however deep it is. The
+category index is not walked: its keys group items by subcategory, so
+sorting by name within a category filters the inventory bucket instead.
+Ties in price are broken by name, so results are deterministic. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// maxListLimit bounds the page size
+const maxListLimit = 1000
+
+// listQuery is a parsed set of list parameters
+type listQuery struct {
+	Prefix   string
+	Contains string
+	Min, Max *dollars
+	Category string
+	Tag      string
+	Sort     string // "name" or "price"
+	Desc     bool
+	Limit    int    // 0 means no limit
+	Cursor   []byte // bucket key of the last item of the previous page
+}
+
+// parseListQuery reads and validates list parameters from q
+func parseListQuery(q url.Values) (lq listQuery, err error) {
+	lq.Prefix = canonicalName(q.Get("prefix"))
+	lq.Contains = canonicalName(q.Get("q"))
+
+	for _, p := range []struct {
+		name string
+		dst  **dollars
+	}{{"min", &lq.Min}, {"max", &lq.Max}} {
+		if v := q.Get(p.name); v != "" {
+			d, err := parseDollars(v)
+			if err == nil {
+				err = checkPrice(d)
+			}
+			if err != nil {
+				return lq, fmt.Errorf("%s: %v", p.name, err)
+			}
+			*p.dst = &d
+		}
+	}
+	if lq.Min != nil && lq.Max != nil && *lq.Min > *lq.Max {
+		return lq, fmt.Errorf("min must not be greater than max")
+	}
+	if lq.Category, err = cleanCategory(q.Get("category")); err != nil {
+		return lq, err
+	}
+	if t := q.Get("tag"); t != "" {
+		if lq.Tag, err = cleanTag(t); err != nil {
+			return lq, err
+		}
+	}
+
+	switch lq.Sort = q.Get("sort"); lq.Sort {
+	case "":
+		lq.Sort = "name"
+	case "name", "price":
+	default:
+		return lq, fmt.Errorf("sort must be name or price")
+	}
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		lq.Desc = true
+	default:
+		return lq, fmt.Errorf("order must be asc or desc")
+	}
+
+	if lq.Limit, err = intParam(q.Get("limit"), 0, 1, maxListLimit); err != nil {
+		return lq, fmt.Errorf("limit: %v", err)
+	}
+
+	if c := q.Get("cursor"); c != "" {
+		// cursors are "<source>.<base64 key>" so they cannot be replayed
+		// against a different sort order or index
+		i := strings.IndexByte(c, '.')
+		if i < 0 || c[:i] != lq.source() {
+			return lq, fmt.Errorf("cursor does not match sort order")
+		}
+		if lq.Cursor, err = base64.RawURLEncoding.DecodeString(c[i+1:]); err != nil || len(lq.Cursor) == 0 {
+			return lq, fmt.Errorf("invalid cursor")
+		}
+	}
+	return lq, nil
+}
+
+// source names the bucket that lq walks: "name" (the inventory bucket),
+// "price" or "tag" (their index buckets)
+func (lq listQuery) source() string {
+	switch {
+	case lq.Sort == "price":
+		return "price"
+	case lq.Tag != "":
+		return "tag"
+	}
+	return "name"
+}
+
+// encodeCursor returns the next_cursor for a page ending at bucket key k
+func (lq listQuery) encodeCursor(k []byte) string {
+	return lq.source() + "." + base64.RawURLEncoding.EncodeToString(k)
+}
+
+// match reports whether it passes the filters of lq
+func (lq listQuery) match(it item) bool {
+	switch {
+	case !strings.HasPrefix(it.Name, lq.Prefix):
+		return false
+	case lq.Contains != "" && !strings.Contains(it.Name, lq.Contains):
+		return false
+	case lq.Min != nil && it.Price < *lq.Min:
+		return false
+	case lq.Max != nil && it.Price > *lq.Max:
+		return false
+	case !it.inCategory(lq.Category):
+		return false
+	case lq.Tag != "" && !it.hasTag(lq.Tag):
+		return false
+	}
+	return true
+}
+
+// query returns one page of items matching lq and the cursor of the next
+// page, or "" if this is the last page
+func (s *server) query(lq listQuery) (items []item, next string, err error) {
+	items = []item{}
+	err = s.store.Tx(false, func(tx StoreTx) error {
+		items, next, err = queryTx(tx, lq)
+		return err
+	})
+	return items, next, err
+}
+
+// queryTx runs lq within tx
+func queryTx(tx StoreTx, lq listQuery) (items []item, next string, err error) {
+	items = []item{}
+	var last []byte
+	err = scanTx(tx, lq, func(k []byte, it item) (bool, error) {
+		if lq.Limit > 0 && len(items) == lq.Limit {
+			// another match exists: the page is full
+			next = lq.encodeCursor(last)
+			return false, nil
+		}
+		items = append(items, it)
+		last = append(last[:0], k...)
+		return true, nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return items, next, nil
+}
+
+// scanTx calls fn with each item matching lq, in order, along with its key
+// in the bucket walked, until fn returns false or an error. Limit is left
+// to fn.
+func scanTx(tx StoreTx, lq listQuery, fn func(k []byte, it item) (bool, error)) error {
+	// walk bucket, resolving each entry to its item with decode;
+	// lo and hi bound the keys worth visiting, nil means unbounded
+	var bucket Bucket
+	var decode func(k, v []byte) (item, error)
+	var lo, hi []byte
+	lookup := func(name string) (item, error) {
+		it, ok, err := tx.Get(name)
+		if err == nil && !ok {
+			err = fmt.Errorf("index entry for %q has no item", name)
+		}
+		return it, err
+	}
+	switch lq.source() {
+	case "price":
+		bucket = tx.Bucket(priceIndexBucket)
+		decode = func(k, _ []byte) (item, error) { return lookup(string(k[8:])) }
+		if lq.Min != nil {
+			lo = lq.Min.bytes()
+		}
+		if lq.Max != nil {
+			hi = (*lq.Max + 1).bytes()
+		}
+	case "tag":
+		bucket = tx.Bucket(tagIndexBucket)
+		decode = func(k, _ []byte) (item, error) { return lookup(indexedName(k)) }
+		lo = tagPrefix(lq.Tag)
+		hi = prefixEnd(lo)
+	default:
+		bucket = tx.Bucket(inventoryBucket)
+		decode = decodeItem
+		if lq.Prefix != "" {
+			lo, hi = []byte(lq.Prefix), prefixEnd([]byte(lq.Prefix))
+		}
+	}
+	if lq.Cursor != nil && (lo != nil && bytes.Compare(lq.Cursor, lo) < 0 || hi != nil && bytes.Compare(lq.Cursor, hi) >= 0) {
+		return nil // the cursor belongs to another range
+	}
+
+	c := bucket.Cursor()
+	var k, v []byte
+	switch {
+	case !lq.Desc && lq.Cursor != nil:
+		if k, v = c.Seek(lq.Cursor); bytes.Equal(k, lq.Cursor) {
+			k, v = c.Next()
+		}
+	case !lq.Desc && lo != nil:
+		k, v = c.Seek(lo)
+	case !lq.Desc:
+		k, v = c.First()
+	default:
+		// descending: start on the last key below the cursor or hi
+		start := lq.Cursor
+		if start == nil {
+			start = hi
+		}
+		if start == nil {
+			k, v = c.Last()
+		} else if k, v = c.Seek(start); k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+	}
+
+	step := c.Next
+	if lq.Desc {
+		step = c.Prev
+	}
+	for ; k != nil; k, v = step() {
+		if !lq.Desc && hi != nil && bytes.Compare(k, hi) >= 0 || lq.Desc && lo != nil && bytes.Compare(k, lo) < 0 {
+			break // past the bounds
+		}
+		it, err := decode(k, v)
+		if err != nil {
+			return err
+		}
+		if !lq.match(it) {
+			continue
+		}
+		if more, err := fn(k, it); err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
+
+// prefixEnd returns the smallest key greater than every key starting with
+// prefix, or nil if there is none
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestListItems checks the filters and both sort orders, and that paging
+// with next_cursor returns every match exactly once
+func TestListItems(t *testing.T) {
+	for name, st := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			s, key := newTestServer(t, st)
+			h := s.handler()
+			for i, name := range []string{"shirt", "shoe", "sock", "hat", "shawl", "scarf", "belt"} {
+				if code, body := send(h, key, "PUT", "/items/"+name, fmt.Sprintf(`{"price": %d}`, i%3)); code != http.StatusCreated {
+					t.Fatalf("create %s: %d %s", name, code, body)
+				}
+			}
+			// moves belt in the price index
+			if code, body := send(h, key, "PUT", "/items/belt", `{"price": 5}`); code != http.StatusOK {
+				t.Fatalf("update belt: %d %s", code, body)
+			}
+
+			for _, c := range []struct {
+				query string
+				want  string
+			}{
+				{"", "belt=500 hat=0 scarf=200 shawl=100 shirt=0 shoe=100 sock=200"},
+				{"sort=price", "hat=0 shirt=0 shawl=100 shoe=100 scarf=200 sock=200 belt=500"},
+				{"sort=price&order=desc&min=1", "belt=500 sock=200 scarf=200 shoe=100 shawl=100"},
+				{"prefix=sh&order=desc", "shoe=100 shirt=0 shawl=100"},
+				{"q=c&max=2", "scarf=200 sock=200"},
+				{"prefix=z", ""},
+			} {
+				var got []string
+				for cursor, pages := "", 0; pages < 10; pages++ {
+					code, body := send(h, key, "GET", "/items?limit=2&"+c.query+cursor, "")
+					var page struct {
+						Items      []item `json:"items"`
+						NextCursor string `json:"next_cursor"`
+					}
+					if code != http.StatusOK || json.Unmarshal([]byte(body), &page) != nil {
+						t.Fatalf("%s: %d %s", c.query, code, body)
+					}
+					for _, it := range page.Items {
+						got = append(got, fmt.Sprintf("%s=%d", it.Name, it.Price))
+					}
+					if page.NextCursor == "" {
+						break
+					}
+					cursor = "&cursor=" + page.NextCursor
+				}
+				if strings.Join(got, " ") != c.want {
+					t.Errorf("%s:\n got %s\nwant %s", c.query, strings.Join(got, " "), c.want)
+				}
+			}
+		})
+	}
+}
+
+// TestListText checks /list with the same parameters, the X-Next-Cursor
+// header and the rejected queries
+func TestListText(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	h := s.handler()
+	for _, name := range []string{"hats", "shawl", "shirt", "socks"} {
+		if code, body := send(h, key, "PUT", "/items/"+name, `{"price": 1}`); code != http.StatusCreated {
+			t.Fatalf("create %s: %d %s", name, code, body)
+		}
+	}
+
+	req := func(target string) (int, string, string) {
+		t.Helper()
+		r := httptest.NewRequest("GET", target, nil)
+		r.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec.Code, rec.Body.String(), rec.Header().Get("X-Next-Cursor")
+	}
+	code, body, next := req("/list?prefix=s&limit=2")
+	if code != http.StatusOK || body != "shawl: $1.00\nshirt: $1.00\n" || next == "" {
+		t.Fatalf("first page: %d %q, next %q", code, body, next)
+	}
+	code, body, next = req("/list?prefix=s&limit=2&cursor=" + next)
+	if code != http.StatusOK || body != "socks: $1.00\n" || next != "" {
+		t.Errorf("last page: %d %q, next %q", code, body, next)
+	}
+
+	for _, target := range []string{
+		"/list?sort=size", "/list?order=up", "/list?limit=0", "/list?min=3&max=1",
+		"/list?min=x", "/list?cursor=nope", "/list?sort=price&cursor=name.aGF0cw",
+	} {
+		if code, body, _ := req(target); code != http.StatusBadRequest {
+			t.Errorf("GET %s: %d %q; want %d", target, code, body, http.StatusBadRequest)
+		}
+	}
+}
+
+// TestListCategoryByName checks that items in a category and its
+// subcategories come back sorted by name, page after page
+func TestListCategoryByName(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	h := s.handler()
+	for name, category := range map[string]string{
+		"zebra": "clothing", "apple": "clothing/shirts", "mango": "clothing/socks",
+		"kiwi": "clothing/shirts/linen", "banana": "food",
+	} {
+		if code, body := send(h, key, "PUT", "/items/"+name, `{"price": 1, "category": "`+category+`"}`); code != http.StatusCreated {
+			t.Fatalf("create %s: %d %s", name, code, body)
+		}
+	}
+
+	for _, c := range []struct {
+		query string
+		want  string
+	}{
+		{"category=clothing", "apple kiwi mango zebra"},
+		{"category=clothing&order=desc", "zebra mango kiwi apple"},
+		{"category=clothing/shirts", "apple kiwi"},
+	} {
+		var names []string
+		for cursor := ""; ; {
+			code, body := send(h, key, "GET", "/items?limit=3&"+c.query+cursor, "")
+			var page struct {
+				Items      []item `json:"items"`
+				NextCursor string `json:"next_cursor"`
+			}
+			if code != http.StatusOK || json.Unmarshal([]byte(body), &page) != nil {
+				t.Fatalf("%s: %d %s", c.query, code, body)
+			}
+			for _, it := range page.Items {
+				names = append(names, it.Name)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			cursor = "&cursor=" + page.NextCursor
+		}
+		if got := strings.Join(names, " "); got != c.want {
+			t.Errorf("%s: %q; want %q", c.query, got, c.want)
+		}
+	}
+}
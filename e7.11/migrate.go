@@ -0,0 +1,374 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// metaBucket holds database bookkeeping such as the schema version
+var metaBucket = []byte("meta")
+
+// schemaKey stores the schema version of the inventory records
+var schemaKey = []byte("schema")
+
+// Schema versions of the inventory bucket:
+//
+//	0: float64 prices (original exercise)
+//	1: int64 cents (type dollars)
+//	2: versioned item records (see record.go); price-only records from
+//	   schema 1 are still read transparently, so no rewrite is needed
+//	3: price index bucket (see store.go)
+//	4: category and tag index buckets (see category.go); no earlier record
+//	   has a category or tags, so they start empty
+//	5: item names in canonical form (see name.go); records whose names
+//	   collide once canonical are merged, and the names in the history,
+//	   movements, orders and schedules are rewritten to match
+//	6: movement index bucket (see stock.go)
+//	7: categories and tags in the canonical form of names (see category.go);
+//	   they were only lower-cased before
+const schemaVersion = 7
+
+// migrate upgrades the records in tx to schemaVersion.
+// It runs inside the read/write transaction that opens the database,
+// so a failed migration leaves the file untouched.
+func migrate(tx StoreTx) error {
+	meta, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return fmt.Errorf("could not load database\n%v", err)
+	}
+	var version uint64
+	if v := meta.Get(schemaKey); v != nil {
+		version = BytesToUint64(v)
+	}
+	if version > schemaVersion {
+		return fmt.Errorf("database schema version %d is newer than supported version %d", version, schemaVersion)
+	}
+
+	if version < 1 {
+		if err := migrateFloatPrices(tx.Bucket(inventoryBucket)); err != nil {
+			return err
+		}
+	}
+
+	if version < 3 {
+		if err := buildPriceIndex(tx); err != nil {
+			return err
+		}
+	}
+
+	if version < 5 {
+		if err := canonicalizeNames(tx); err != nil {
+			return err
+		}
+	}
+
+	if version < 6 {
+		if err := buildMovementIndex(tx); err != nil {
+			return err
+		}
+	}
+
+	if version < 7 {
+		if err := canonicalizeLabels(tx); err != nil {
+			return err
+		}
+	}
+
+	return meta.Put(schemaKey, Uint64ToBytes(schemaVersion))
+}
+
+// migrateFloatPrices rewrites float64 prices as cents, rounding to the
+// nearest cent
+func migrateFloatPrices(b Bucket) error {
+	converted := make(map[string]dollars)
+	if err := b.ForEach(func(k, v []byte) error {
+		if len(v) != 8 {
+			return fmt.Errorf("migrate %q: unexpected record length %d", k, len(v))
+		}
+		converted[string(k)] = dollarsFromFloat(BytesToFloat64(v))
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	// stores do not allow modifying a bucket while iterating over it
+	for k, d := range converted {
+		if err := b.Put([]byte(k), d.bytes()); err != nil {
+			return fmt.Errorf("migrate %q: %v", k, err)
+		}
+	}
+	if len(converted) > 0 {
+		log.Printf("migrated %d prices from float to cents", len(converted))
+	}
+	return nil
+}
+
+// buildPriceIndex adds a price index entry for every existing item
+func buildPriceIndex(tx StoreTx) error {
+	items, err := tx.List()
+	if err != nil {
+		return err
+	}
+	idx := tx.Bucket(priceIndexBucket)
+	for _, it := range items {
+		if err := idx.Put(priceIndexKey(it), nil); err != nil {
+			return fmt.Errorf("index %q: %v", it.Name, err)
+		}
+	}
+	return nil
+}
+
+// buildMovementIndex adds a movement index entry for every recorded
+// movement
+func buildMovementIndex(tx StoreTx) error {
+	idx := tx.Bucket(movementIndexBucket)
+	return tx.Bucket(movementsBucket).ForEach(func(k, v []byte) error {
+		var m movement
+		if err := json.Unmarshal(v, &m); err != nil {
+			return fmt.Errorf("index movement %d: %v", BytesToUint64(k), err)
+		}
+		return idx.Put(movementIndexKey(m.Item, m.ID), nil)
+	})
+}
+
+// canonicalizeLabels puts the category and tags of every item in their
+// current canonical form; Put moves their index entries too. An item whose
+// labels are no longer valid once canonical, say too long, is left alone.
+func canonicalizeLabels(tx StoreTx) error {
+	items, err := tx.List()
+	if err != nil {
+		return err
+	}
+	n := 0
+	for _, it := range items {
+		clean := it
+		if err := clean.normalize(); err != nil {
+			log.Printf("migrate %q: %v; category and tags left as is", it.Name, err)
+			continue
+		}
+		if clean.Category == it.Category && strings.Join(clean.Tags, ";") == strings.Join(it.Tags, ";") {
+			continue
+		}
+		if err := tx.Put(clean); err != nil {
+			return fmt.Errorf("migrate %q: %v", it.Name, err)
+		}
+		n++
+	}
+	if n > 0 {
+		log.Printf("migrated the categories and tags of %d items", n)
+	}
+	return nil
+}
+
+// migrationActor is recorded in the history for changes made by migrate
+const migrationActor = "migration"
+
+// canonicalizeNames stores every item under its canonical name. Items
+// whose names collide are merged into one record: the most recently
+// updated one, preferring the one already stored under the canonical name
+// on a tie, with the sum of their quantities. Each merge is recorded in
+// the history as an update of the canonical name. The history and its
+// index, movements, orders and schedules are re-keyed to canonical names,
+// so an item's history includes that of the records merged into it. Names
+// that are invalid even in canonical form are left alone.
+// The merged records do not depend on the clock, so a follower migrating
+// its copy ends up with the same items as its leader.
+func canonicalizeNames(tx StoreTx) error {
+	items, err := tx.List()
+	if err != nil {
+		return err
+	}
+	groups := make(map[string][]item)
+	var names []string
+	for _, it := range items {
+		c := canonicalName(it.Name)
+		if groups[c] == nil {
+			names = append(names, c)
+		}
+		groups[c] = append(groups[c], it)
+	}
+	sort.Strings(names)
+
+	var merges []change
+	for _, c := range names {
+		group := groups[c]
+		if len(group) == 1 && group[0].Name == c {
+			continue
+		}
+		if err := checkName(c); err != nil {
+			log.Printf("migrate %q: %v; left as is", group[0].Name, err)
+			continue
+		}
+		merged := group[0]
+		var qty int64
+		var version uint64
+		for _, it := range group {
+			if it.Updated.After(merged.Updated) || it.Updated.Equal(merged.Updated) && it.Name == c {
+				merged = it
+			}
+			qty += it.Quantity
+			if it.Version > version {
+				version = it.Version
+			}
+		}
+		if qty > maxQuantity {
+			log.Printf("migrate %q: merged quantity %d capped at %d", c, qty, int64(maxQuantity))
+			qty = maxQuantity
+		}
+		var from []string
+		for _, it := range group {
+			if err := tx.Delete(it.Name); err != nil {
+				return fmt.Errorf("migrate %q: %v", it.Name, err)
+			}
+			from = append(from, fmt.Sprintf("%q", it.Name))
+		}
+		old := merged
+		old.Name = c
+		merged.Name, merged.Quantity, merged.Version = c, qty, version+1
+		if err := tx.Put(merged); err != nil {
+			return fmt.Errorf("migrate %q: %v", c, err)
+		}
+		merges = append(merges, change{Op: opUpdate, Item: c, Old: &old, New: &merged})
+		log.Printf("migrated %s to %q", strings.Join(from, ", "), c)
+	}
+
+	if err := rekeyNames(tx); err != nil {
+		return err
+	}
+	w := &writeTx{tx: tx, actor: migrationActor, now: time.Now().UTC()}
+	for _, c := range merges {
+		if err := w.record(c); err != nil {
+			return fmt.Errorf("migrate %q: %v", c.Item, err)
+		}
+	}
+	return nil
+}
+
+// rekeyNames rewrites the item names held by the history and its index,
+// movements, orders and schedules in canonical form. The movement index
+// is built afterwards, from the rewritten movements (schema 6).
+func rekeyNames(tx StoreTx) error {
+	canon := func(name string) string {
+		if c := canonicalName(name); checkName(c) == nil {
+			return c
+		}
+		return name
+	}
+	// rewrite calls fn with every record of bucket, storing back those it
+	// changed once the walk is done
+	rewrite := func(bucket []byte, fn func(v []byte) ([]byte, error)) error {
+		b := tx.Bucket(bucket)
+		changed := make(map[string][]byte)
+		if err := b.ForEach(func(k, v []byte) error {
+			nv, err := fn(v)
+			if err != nil {
+				return fmt.Errorf("migrate %s %x: %v", bucket, k, err)
+			}
+			if nv != nil {
+				changed[string(k)] = nv
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for k, v := range changed {
+			if err := b.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := rewrite(historyBucket, func(v []byte) ([]byte, error) {
+		ch, err := decodeChange(v)
+		if err != nil || canon(ch.Item) == ch.Item {
+			return nil, err
+		}
+		ch.Item = canon(ch.Item)
+		for _, it := range []*item{ch.Old, ch.New} {
+			if it != nil {
+				it.Name = canon(it.Name)
+			}
+		}
+		return json.Marshal(ch)
+	}); err != nil {
+		return err
+	}
+	idx := tx.Bucket(historyIndexBucket)
+	var stale [][]byte
+	if err := idx.ForEach(func(k, _ []byte) error {
+		if name := string(k[:len(k)-9]); canon(name) != name {
+			stale = append(stale, append([]byte(nil), k...))
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, k := range stale {
+		name, seq := string(k[:len(k)-9]), BytesToUint64(k[len(k)-8:])
+		if err := idx.Delete(k); err != nil {
+			return err
+		}
+		if err := idx.Put(historyIndexKey(canon(name), seq), nil); err != nil {
+			return err
+		}
+	}
+
+	if err := rewrite(movementsBucket, func(v []byte) ([]byte, error) {
+		var m movement
+		if err := json.Unmarshal(v, &m); err != nil || canon(m.Item) == m.Item {
+			return nil, err
+		}
+		m.Item = canon(m.Item)
+		return json.Marshal(m)
+	}); err != nil {
+		return err
+	}
+
+	if err := rewrite(ordersBucket, func(v []byte) ([]byte, error) {
+		var o order
+		if err := json.Unmarshal(v, &o); err != nil {
+			return nil, err
+		}
+		// lines for names that collide are combined, as mergeLines would
+		// have done had the names been canonical when the order was placed
+		lines := make([]orderLine, 0, len(o.Lines))
+		at := make(map[string]int)
+		renamed := false
+		for _, l := range o.Lines {
+			if c := canon(l.Item); c != l.Item {
+				l.Item, renamed = c, true
+			}
+			if i, ok := at[l.Item]; ok {
+				lines[i].Quantity += l.Quantity
+				continue
+			}
+			at[l.Item] = len(lines)
+			lines = append(lines, l)
+		}
+		if !renamed {
+			return nil, nil
+		}
+		sort.Slice(lines, func(i, j int) bool { return lines[i].Item < lines[j].Item })
+		o.Lines = lines
+		return json.Marshal(o)
+	}); err != nil {
+		return err
+	}
+
+	moved, err := listSchedules(tx, func(sc schedule) bool { return canon(sc.Item) != sc.Item })
+	if err != nil {
+		return err
+	}
+	for _, sc := range moved {
+		sc.Item = canon(sc.Item)
+		if err := putSchedule(tx, sc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
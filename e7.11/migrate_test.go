@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCanonicalizeNames migrates a schema 4 database holding "Shirts" and
+// "shirts" and checks that they are merged into "shirts", taking the most
+// recently updated record and the sum of the quantities, that their
+// history, movements, orders and schedules all follow them, and that the
+// merge itself is in the history
+func TestCanonicalizeNames(t *testing.T) {
+	st := newMemStore()
+	if err := prepareStore(st); err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	err := st.Tx(true, func(tx StoreTx) error {
+		w := &writeTx{tx: tx, actor: "till", now: t0}
+		for i, it := range []item{
+			{Name: "Shirts", Price: 500, Quantity: 2, Unit: defaultUnit, Updated: t0, Version: 1},
+			{Name: "shirts", Price: 400, Quantity: 5, Unit: defaultUnit, Updated: t0.Add(time.Hour), Version: 1},
+		} {
+			if err := tx.Put(it); err != nil {
+				return err
+			}
+			if err := w.record(change{Op: opCreate, Item: it.Name, New: &it}); err != nil {
+				return err
+			}
+			v, _ := json.Marshal(movement{ID: uint64(i + 1), Item: it.Name, Kind: "in", Quantity: it.Quantity, Balance: it.Quantity, Time: t0})
+			if err := tx.Bucket(movementsBucket).Put(Uint64ToBytes(uint64(i+1)), v); err != nil {
+				return err
+			}
+		}
+		if err := tx.Put(item{Name: " Hats", Price: 100, Unit: defaultUnit, Updated: t0, Version: 3}); err != nil {
+			return err
+		}
+		if err := putSchedule(tx, schedule{ID: 1, Item: " Hats", Price: 200, Start: t0.Add(24 * time.Hour), Status: schedPending}); err != nil {
+			return err
+		}
+		v, _ := json.Marshal(order{ID: 1, Key: "k1", Lines: []orderLine{{"Shirts", 1, 500}, {"shirts", 2, 400}}, Total: 1300, Created: t0})
+		if err := tx.Bucket(ordersBucket).Put([]byte("k1"), v); err != nil {
+			return err
+		}
+		return tx.Bucket(metaBucket).Put(schemaKey, Uint64ToBytes(4))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, key := newTestServer(t, st) // migrates
+	h := s.handler()
+	for _, c := range []struct {
+		path  string
+		want  []string
+		count string
+		n     int
+	}{
+		{"/items/shirts", []string{`"price":4.00`, `"quantity":7`, `"version":2`}, "", 0},
+		{"/items/shirts/history", []string{`"actor":"till"`, `"actor":"migration"`, `"quantity":7`}, `"seq":`, 3},
+		{"/items/shirts/movements", []string{`"item":"shirts"`}, `"kind":"in"`, 2},
+		{"/history?at=" + url.QueryEscape(time.Now().UTC().Format(time.RFC3339)), []string{`"name":"shirts"`}, `"name":"shirts"`, 1},
+		{"/items/hats", []string{`"name":"hats"`, `"version":4`}, "", 0},
+		{"/items/hats/schedules", []string{`"item":"hats"`}, "", 0},
+		{"/list", []string{"hats: $1.00\nshirts: $4.00\n"}, "", 0},
+	} {
+		code, body := send(h, key, "GET", c.path, "")
+		if code != http.StatusOK {
+			t.Errorf("GET %s: %d %s", c.path, code, body)
+			continue
+		}
+		for _, w := range c.want {
+			if !strings.Contains(body, w) {
+				t.Errorf("GET %s: %s; want %s", c.path, body, w)
+			}
+		}
+		if c.count != "" && strings.Count(body, c.count) != c.n {
+			t.Errorf("GET %s: %s; want %d of %s", c.path, body, c.n, c.count)
+		}
+	}
+
+	o, replayed, err := s.placeOrder("till", "k1", []orderLine{{Item: "shirts", Quantity: 3}})
+	if err != nil || !replayed || len(o.Lines) != 1 {
+		t.Errorf("replaying order: %+v, %v, %v; want the stored order with one line", o, replayed, err)
+	}
+}
@@ -0,0 +1,55 @@
+/* Item names.
+Item names are the keys of the inventory, so every path that takes a name
+from a client (the JSON API, the text endpoints, batches, orders, imports,
+list filters and search) puts it in canonical form with canonicalName
+before using it: surrounding white space is trimmed, the text is
+normalized to NFC and case folded with the full Unicode rules, so
+"Shirts", " shirts" and "SHIRTS" are one item, as are "Straße" and
+"STRASSE", and "café" typed with a combining accent and with a
+precomposed é. Stored names must also pass checkName. Records stored
+under keys that were not canonical are merged by the schema 5 migration
+(see migrate.go). */
+
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxNameLen bounds item names, in characters
+const maxNameLen = 128
+
+// canonicalName returns name in canonical form. It does not validate it;
+// a name that fails checkName cannot be stored, so lookups of it simply
+// find nothing.
+func canonicalName(name string) string {
+	name = strings.TrimSpace(name)
+	// folding may leave text that is no longer in NFC, so normalize on
+	// both sides; a Caser is not safe for concurrent use, so make one each
+	// time
+	return norm.NFC.String(cases.Fold().String(norm.NFC.String(name)))
+}
+
+// checkName reports whether name, already in canonical form, may be stored
+func checkName(name string) error {
+	switch {
+	case name == "":
+		return invalidf("item name required")
+	case !utf8.ValidString(name):
+		return invalidf("item name must be valid UTF-8")
+	case utf8.RuneCountInString(name) > maxNameLen:
+		return invalidf("item name must be at most %d characters", maxNameLen)
+	case strings.ContainsRune(name, '/'):
+		return invalidf("item name must not contain '/'")
+	case strings.IndexFunc(name, unicode.IsControl) >= 0:
+		return invalidf("item name must not contain control characters")
+	case canonicalName(name) != name:
+		return invalidf("item name %q is not in canonical form", name)
+	}
+	return nil
+}
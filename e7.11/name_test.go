@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalName(t *testing.T) {
+	for _, c := range []struct{ in, want string }{
+		{" Shirts ", "shirts"},
+		{"SHIRTS", "shirts"},
+		{"Straße", "strasse"},
+		{"cafe\u0301", "caf\u00e9"}, // combining accent to precomposed
+		{"CAFÉ", "café"},
+	} {
+		if got := canonicalName(c.in); got != c.want {
+			t.Errorf("canonicalName(%q) = %q; want %q", c.in, got, c.want)
+		}
+	}
+	for _, name := range []string{"", "a/b", "tab\there", strings.Repeat("x", maxNameLen+1), "Shirts"} {
+		if checkName(name) == nil {
+			t.Errorf("checkName(%q) accepted it", name)
+		}
+	}
+}
+
+// TestNamesOnEveryPath checks that the spellings of one name reach the same
+// item through each kind of endpoint
+func TestNamesOnEveryPath(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	h := s.handler()
+	if code, body := send(h, key, "PUT", "/items/Stra%C3%9Fe", `{"price": 1}`); code != http.StatusCreated {
+		t.Fatalf("create: %d %s", code, body)
+	}
+	for _, c := range []struct {
+		method, path, body string
+		code               int
+		want               string
+	}{
+		{"GET", "/items/STRASSE", "", http.StatusOK, `"name":"strasse"`},
+		{"GET", "/price?item=%20Strasse", "", http.StatusOK, "$1.00\n"},
+		{"POST", "/items", `{"name": "STRASSE", "price": 2}`, http.StatusConflict, "already exists"},
+		{"POST", "/batch", `{"ops": [{"op": "upsert", "item": "Straße", "price": 3}]}`, http.StatusOK, `"status":200`},
+		{"POST", "/import", "name,price\nSTRASSE,4\n", http.StatusOK, `"updated":1`},
+		{"GET", "/list?prefix=STR", "", http.StatusOK, "strasse: $4.00\n"},
+		{"PUT", "/items/a%09b", `{"price": 1}`, http.StatusUnprocessableEntity, "control characters"},
+	} {
+		if code, body := send(h, key, c.method, c.path, c.body); code != c.code || !strings.Contains(body, c.want) {
+			t.Errorf("%s %s %s: %d %s; want %d containing %q", c.method, c.path, c.body, code, body, c.code, c.want)
+		}
+	}
+}
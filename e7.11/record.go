@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+	"unicode/utf8"
+)
+
+// item is the record stored for each inventory entry
+type item struct {
+	Name        string    `json:"name"`
+	SKU         string    `json:"sku"`
+	Description string    `json:"description"`
+	Price       dollars   `json:"price"`
+	Quantity    int64     `json:"quantity"`                // quantity on hand
+	Reorder     int64     `json:"reorder_level,omitempty"` // low stock at or below this; 0 for none
+	Unit        string    `json:"unit"`                    // unit of measure, e.g. "each", "box"
+	Category    string    `json:"category,omitempty"`      // e.g. "clothing/shirts"; see category.go
+	Tags        []string  `json:"tags,omitempty"`          // sorted
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+	Version     uint64    `json:"version"` // bumped on every write; see etag.go
+}
+
+// recordV1 prefixes a JSON encoded item record.
+// Price-only records (schema 1) are exactly 8 bytes of cents and never
+// start with a version byte followed by JSON.
+const recordV1 byte = 1
+
+// defaultUnit is reported for records that predate the unit field
+const defaultUnit = "each"
+
+// Field limits for item records
+const (
+	maxSKULen         = 64
+	maxDescriptionLen = 1024
+	maxUnitLen        = 32
+	maxQuantity       = 1e9
+)
+
+// encodeItem serializes it for storage in the inventory bucket
+func encodeItem(it item) ([]byte, error) {
+	b, err := json.Marshal(it)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{recordV1}, b...), nil
+}
+
+// decodeItem deserializes the record stored under key k. Price-only
+// records are read transparently as items with default fields.
+func decodeItem(k, v []byte) (item, error) {
+	if len(v) == 8 {
+		return item{Name: string(k), Price: bytesToDollars(v), Unit: defaultUnit}, nil
+	}
+	if len(v) == 0 || v[0] != recordV1 {
+		return item{}, fmt.Errorf("item %q: unknown record format", k)
+	}
+	var it item
+	if err := json.Unmarshal(v[1:], &it); err != nil {
+		return item{}, fmt.Errorf("item %q: %v", k, err)
+	}
+	it.Name = string(k)
+	return it, nil
+}
+
+// invalidError reports a request that failed validation, as opposed to a
+// database failure
+type invalidError struct{ error }
+
+// invalidf formats an invalidError
+func invalidf(format string, args ...interface{}) error {
+	return invalidError{fmt.Errorf(format, args...)}
+}
+
+// validate checks the fields of it before it is stored
+func (it item) validate() error {
+	if err := checkName(it.Name); err != nil {
+		return err
+	}
+	if err := checkPrice(it.Price); err != nil {
+		return invalidError{err}
+	}
+	switch {
+	case it.Quantity < 0:
+		return invalidError{errors.New("quantity must be greater than or equal to 0")}
+	case it.Quantity > maxQuantity:
+		return invalidf("quantity must be at most %d", int64(maxQuantity))
+	case it.Reorder < 0 || it.Reorder > maxQuantity:
+		return invalidf("reorder_level must be between 0 and %d", int64(maxQuantity))
+	case utf8.RuneCountInString(it.SKU) > maxSKULen:
+		return invalidf("sku must be at most %d characters", maxSKULen)
+	case utf8.RuneCountInString(it.Description) > maxDescriptionLen:
+		return invalidf("description must be at most %d characters", maxDescriptionLen)
+	case utf8.RuneCountInString(it.Unit) > maxUnitLen:
+		return invalidf("unit must be at most %d characters", maxUnitLen)
+	}
+	return nil
+}
+
+// equal reports whether it and o hold the same values
+func (it item) equal(o item) bool {
+	if len(it.Tags) != len(o.Tags) {
+		return false
+	}
+	for i := range it.Tags {
+		if it.Tags[i] != o.Tags[i] {
+			return false
+		}
+	}
+	return it.Name == o.Name && it.SKU == o.SKU && it.Description == o.Description &&
+		it.Price == o.Price && it.Quantity == o.Quantity && it.Reorder == o.Reorder && it.Unit == o.Unit &&
+		it.Category == o.Category && it.Created.Equal(o.Created) &&
+		it.Updated.Equal(o.Updated) && it.Version == o.Version
+}
+
+// lowStock reports whether it has a reorder level and is at or below it
+func (it item) lowStock() bool {
+	return it.Reorder > 0 && it.Quantity <= it.Reorder
+}
+
+// sortItems sorts items by name, the order of the inventory bucket
+func sortItems(items []item) {
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+}
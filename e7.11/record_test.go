@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestItemRecords checks that PUT stores the fields it is given, leaves the
+// others alone and validates the result
+func TestItemRecords(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	h := s.handler()
+	get := func(name string) (it item) {
+		t.Helper()
+		code, body := send(h, key, "GET", "/items/"+name, "")
+		if code != http.StatusOK || json.Unmarshal([]byte(body), &it) != nil {
+			t.Fatalf("GET %s: %d %s", name, code, body)
+		}
+		return it
+	}
+
+	if code, body := send(h, key, "PUT", "/items/hats", `{"price": 15, "sku": "H-1", "description": "felt", "quantity": 3, "unit": "box"}`); code != http.StatusCreated {
+		t.Fatalf("create: %d %s", code, body)
+	}
+	created := get("hats")
+	if created.SKU != "H-1" || created.Description != "felt" || created.Price != 1500 || created.Quantity != 3 || created.Unit != "box" ||
+		created.Created.IsZero() || !created.Updated.Equal(created.Created) {
+		t.Errorf("created %+v", created)
+	}
+
+	time.Sleep(time.Millisecond)
+	if code, body := send(h, key, "PUT", "/items/hats", `{"quantity": 5}`); code != http.StatusOK {
+		t.Fatalf("update: %d %s", code, body)
+	}
+	it := get("hats")
+	if it.Quantity != 5 || it.SKU != "H-1" || it.Price != 1500 || it.Unit != "box" ||
+		!it.Created.Equal(created.Created) || !it.Updated.After(created.Updated) {
+		t.Errorf("after setting the quantity: %+v", it)
+	}
+
+	send(h, key, "PUT", "/items/socks", `{"price": 2}`)
+	if it := get("socks"); it.Unit != defaultUnit || it.Quantity != 0 {
+		t.Errorf("defaults: %+v", it)
+	}
+
+	for _, body := range []string{
+		`{"quantity": -1}`,
+		`{"sku": "` + strings.Repeat("x", maxSKULen+1) + `"}`,
+		`{"description": "` + strings.Repeat("é", maxDescriptionLen+1) + `"}`,
+		`{"unit": "` + strings.Repeat("x", maxUnitLen+1) + `"}`,
+	} {
+		if code, resp := send(h, key, "PUT", "/items/hats", body); code != http.StatusUnprocessableEntity {
+			t.Errorf("PUT %.40s: %d %s; want %d", body, code, resp, http.StatusUnprocessableEntity)
+		}
+	}
+	if it := get("hats"); it.Quantity != 5 || it.SKU != "H-1" {
+		t.Errorf("a refused PUT changed hats: %+v", it)
+	}
+}
+
+// TestPriceOnlyRecords checks that a record holding only a price, as schema
+// 1 stored it, reads as an item with default fields and is rewritten in
+// the current format when next saved
+func TestPriceOnlyRecords(t *testing.T) {
+	st := openTestBolt(t)
+	s, key := newTestServer(t, st)
+	if err := st.Tx(true, func(tx StoreTx) error {
+		return tx.Bucket(inventoryBucket).Put([]byte("hats"), dollars(1999).bytes())
+	}); err != nil {
+		t.Fatal(err)
+	}
+	it, ok, err := s.lookup("hats")
+	if err != nil || !ok || !reflect.DeepEqual(it, item{Name: "hats", Price: 1999, Unit: defaultUnit}) {
+		t.Fatalf("price-only record: %+v, %v, %v", it, ok, err)
+	}
+
+	if code, body := send(s.handler(), key, "PUT", "/items/hats", `{"quantity": 2}`); code != http.StatusOK {
+		t.Fatalf("update: %d %s", code, body)
+	}
+	st.Tx(false, func(tx StoreTx) error {
+		v := tx.Bucket(inventoryBucket).Get([]byte("hats"))
+		if len(v) == 0 || v[0] != recordV1 {
+			t.Errorf("record after saving: %q", v)
+		}
+		return nil
+	})
+	if it, _, _ := s.lookup("hats"); it.Price != 1999 || it.Quantity != 2 {
+		t.Errorf("after saving: %+v", it)
+	}
+
+	if _, err := decodeItem([]byte("socks"), []byte{9, '{', '}'}); err == nil {
+		t.Error("decodeItem accepted an unknown record format")
+	}
+}
@@ -0,0 +1,514 @@
+/* Leader/follower replication.
+Any server can lead: its history (see history.go) is the ordered change log.
+GET /replication/log?after=seq&limit=500&wait=25&keys=digest  changes after
+	seq, oldest first; with wait (seconds) the request is held until there
+	is one, or until the API keys no longer match digest
+GET /replication/status                           role, position and lag
+A follower (item_server -follow http://leader:8000 -leader-key <admin key>)
+copies the leader's database from /admin/backup, then tails the log and
+applies each change to its own file, keeping the leader's sequence numbers
+so /history and /events work on it too. It only serves reads; writes are
+refused with 403. After a restart it resumes from its last applied change.
+Every database has a generation, which a restore replaces; the log carries
+the leader's, and when it is no longer the one the follower copied, or the
+leader's log is behind the follower, the follower copies the database again.
+Replicated are the inventory with its indexes and the history, change by
+change through the log, and the API keys: a follower sends a digest of its
+keys with each poll, and the leader answers at once with all of its keys
+when they differ, so a key issued or revoked on the leader soon works or
+stops working on followers too. Schedules, movements and orders are not
+replicated; on a follower they are as they were at the last copy. A
+follower runs no scheduler: prices set by the leader's schedules arrive as
+changes, but the schedules' own status does not. */
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// replicaKey in the meta bucket records the leader a follower's database
+// was copied from
+var replicaKey = []byte("replica_of")
+
+// generationKey in the meta bucket identifies the history of a database.
+// It is set when the database is created and replaced when it is restored,
+// so a follower can tell that the log it tails is not the one it copied.
+var generationKey = []byte("generation")
+
+// Replication tuning
+const (
+	maxLogLimit       = 1000
+	replicaBatch      = 500
+	replicaWait       = 25 * time.Second // long poll held by the leader
+	maxLogWait        = 60
+	replicaMinBackoff = time.Second
+	replicaMaxBackoff = 30 * time.Second
+)
+
+// errLeaderBehind means the leader's log ends before the follower's
+// position, so the follower must copy the database again
+var errLeaderBehind = errors.New("leader log is behind this follower; copying the database again")
+
+// errLeaderReplaced means the leader's database was restored since the
+// follower copied it, so the follower must copy it again
+var errLeaderReplaced = errors.New("leader database was replaced; copying it again")
+
+// changeLog is the body of GET /replication/log
+type changeLog struct {
+	Changes    []change `json:"changes"`
+	LastSeq    uint64   `json:"last_seq"`       // latest change on the leader
+	Generation string   `json:"generation"`     // of the leader's database
+	Keys       []apiKey `json:"keys,omitempty"` // the leader's API keys, if they differ from the follower's
+}
+
+// replicaStatus is the body of GET /replication/status
+type replicaStatus struct {
+	Role        string     `json:"role"`             // "leader" or "follower"
+	Leader      string     `json:"leader,omitempty"` // URL followed
+	AppliedSeq  uint64     `json:"applied_seq"`      // latest change stored here
+	LeaderSeq   uint64     `json:"leader_seq,omitempty"`
+	Behind      uint64     `json:"behind"`                 // changes not yet applied
+	LagSeconds  float64    `json:"lag_seconds"`            // time since last caught up; 0 when current
+	LastContact *time.Time `json:"last_contact,omitempty"` // last successful request to the leader
+	Error       string     `json:"error,omitempty"`        // last replication error, if not since resolved
+}
+
+// replica follows a leader, applying its changes to the server's store
+type replica struct {
+	s      *server
+	leader string // base URL, without trailing slash
+	key    string // API key with the admin role on the leader
+	client *http.Client
+
+	mu          sync.Mutex
+	applied     uint64
+	leaderSeq   uint64
+	caughtUp    time.Time
+	lastContact time.Time
+	lastErr     string
+}
+
+// newReplica makes s a follower of the server at leader; start it with run
+func newReplica(s *server, leader, key string) (*replica, error) {
+	u, err := url.Parse(leader)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("leader must be an http(s) URL: %q", leader)
+	}
+	if _, ok := s.store.(snapshotter); !ok {
+		return nil, errors.New("a follower needs a store that supports snapshots")
+	}
+	r := &replica{s: s, leader: strings.TrimSuffix(leader, "/"), key: key, client: &http.Client{}}
+	s.replica = r
+	return r, nil
+}
+
+// run replicates until ctx is done, retrying with backoff after errors
+func (r *replica) run(ctx context.Context) {
+	delay := replicaMinBackoff
+	for {
+		progressed, err := r.follow(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		r.mu.Lock()
+		r.lastErr = err.Error()
+		r.mu.Unlock()
+		log.Printf("replication: %v", err)
+		if progressed {
+			delay = replicaMinBackoff
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		if delay *= 2; delay > replicaMaxBackoff {
+			delay = replicaMaxBackoff
+		}
+	}
+}
+
+// follow copies the leader's database if needed, then applies its log
+// until an error occurs. progressed reports whether anything was applied.
+func (r *replica) follow(ctx context.Context) (progressed bool, err error) {
+	var applied uint64
+	var current bool
+	if err := r.s.store.Tx(false, func(tx StoreTx) error {
+		meta := tx.Bucket(metaBucket)
+		current = meta != nil && string(meta.Get(replicaKey)) == r.leader
+		applied = lastSeq(tx)
+		return nil
+	}); err != nil {
+		return false, err
+	}
+	if !current {
+		if applied, err = r.bootstrap(ctx); err != nil {
+			return false, err
+		}
+		progressed = true
+	}
+	var gen string
+	if err := r.s.store.Tx(false, func(tx StoreTx) error {
+		gen = generationOf(tx)
+		return nil
+	}); err != nil {
+		return progressed, err
+	}
+	r.mu.Lock()
+	r.applied = applied
+	r.mu.Unlock()
+
+	// the first request returns at once, so the leader's position is
+	// known before waiting for new changes
+	for wait := time.Duration(0); ; wait = replicaWait {
+		keys, err := r.keysDigest()
+		if err != nil {
+			return progressed, err
+		}
+		cl, err := r.fetch(ctx, applied, keys, wait)
+		if err != nil {
+			return progressed, err
+		}
+		if cl.Generation != gen || cl.LastSeq < applied {
+			if err := r.forgetLeader(); err != nil {
+				return progressed, err
+			}
+			if cl.Generation != gen {
+				return progressed, errLeaderReplaced
+			}
+			return progressed, errLeaderBehind
+		}
+		if err := r.apply(cl.Changes); err != nil {
+			return progressed, err
+		}
+		if cl.Keys != nil {
+			if err := r.syncKeys(cl.Keys); err != nil {
+				return progressed, err
+			}
+		}
+		if n := len(cl.Changes); n > 0 {
+			applied, progressed = cl.Changes[n-1].Seq, true
+		}
+
+		now := time.Now()
+		r.mu.Lock()
+		r.applied, r.leaderSeq, r.lastContact, r.lastErr = applied, cl.LastSeq, now, ""
+		if applied >= cl.LastSeq {
+			r.caughtUp = now
+		}
+		r.mu.Unlock()
+	}
+}
+
+// bootstrap replaces the local database with a copy of the leader's and
+// returns the sequence number it is current to
+func (r *replica) bootstrap(ctx context.Context) (uint64, error) {
+	resp, err := r.get(ctx, "/admin/backup")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	prepare := func(st InventoryStore) error {
+		if err := prepareStore(st); err != nil {
+			return err
+		}
+		return st.Tx(true, func(tx StoreTx) error {
+			return tx.Bucket(metaBucket).Put(replicaKey, []byte(r.leader))
+		})
+	}
+	if err := r.s.store.(snapshotter).Restore(resp.Body, prepare); err != nil {
+		return 0, fmt.Errorf("copying leader database: %v", err)
+	}
+
+	var seq uint64
+	err = r.s.store.Tx(false, func(tx StoreTx) error {
+		seq = lastSeq(tx)
+		return nil
+	})
+	log.Printf("replication: copied database from %s at change %d", r.leader, seq)
+	if err == nil {
+		err = r.s.names.load(r.s.store)
+	}
+	r.s.feed.notify()
+	return seq, err
+}
+
+// setGeneration gives the database in tx a new generation; unless replace
+// it only does so if the database has none
+func setGeneration(tx StoreTx, replace bool) error {
+	meta := tx.Bucket(metaBucket)
+	if !replace && meta.Get(generationKey) != nil {
+		return nil
+	}
+	gen, err := randomString(16, hex.EncodeToString)
+	if err != nil {
+		return err
+	}
+	return meta.Put(generationKey, []byte(gen))
+}
+
+// generationOf returns the generation of the database in tx
+func generationOf(tx StoreTx) string {
+	return string(tx.Bucket(metaBucket).Get(generationKey))
+}
+
+// forgetLeader clears the record of the copied leader, so the next
+// attempt copies the database again
+func (r *replica) forgetLeader() error {
+	return r.s.store.Tx(true, func(tx StoreTx) error {
+		return tx.Bucket(metaBucket).Delete(replicaKey)
+	})
+}
+
+// keysDigest summarizes the follower's API keys for the leader
+func (r *replica) keysDigest() (digest string, err error) {
+	err = r.s.store.Tx(false, func(tx StoreTx) error {
+		keys, err := listKeys(tx)
+		if err == nil {
+			digest, err = keysDigest(keys)
+		}
+		return err
+	})
+	return digest, err
+}
+
+// syncKeys replaces the follower's API keys with the leader's
+func (r *replica) syncKeys(keys []apiKey) error {
+	return r.s.store.Tx(true, func(tx StoreTx) error {
+		b := tx.Bucket(apiKeysBucket)
+		var ids [][]byte
+		if err := b.ForEach(func(k, _ []byte) error {
+			ids = append(ids, append([]byte(nil), k...))
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := b.Delete(id); err != nil {
+				return err
+			}
+		}
+		for _, k := range keys {
+			v, err := json.Marshal(k)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(k.ID), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// fetch asks the leader for changes after seq, waiting up to wait for one
+// or for its API keys to differ from those summarized by keys
+func (r *replica) fetch(ctx context.Context, seq uint64, keys string, wait time.Duration) (cl changeLog, err error) {
+	path := fmt.Sprintf("/replication/log?after=%d&limit=%d&wait=%d&keys=%s", seq, replicaBatch, int(wait/time.Second), keys)
+	ctx, cancel := context.WithTimeout(ctx, wait+30*time.Second)
+	defer cancel()
+	resp, err := r.get(ctx, path)
+	if err != nil {
+		return cl, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&cl); err != nil {
+		return cl, fmt.Errorf("reading change log: %v", err)
+	}
+	return cl, nil
+}
+
+// get requests path from the leader, turning error responses into errors
+func (r *replica) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.leader+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+r.key)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var e apiError
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(body, &e) != nil || e.Message == "" {
+			e.Message = strings.TrimSpace(string(body))
+		}
+		return nil, fmt.Errorf("leader: %s: %s", resp.Status, e.Message)
+	}
+	return resp, nil
+}
+
+// apply stores changes from the leader in one transaction, recording them
+// in the local history under the leader's sequence numbers
+func (r *replica) apply(changes []change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	err := r.s.store.Tx(true, func(tx StoreTx) error {
+		last := lastSeq(tx)
+		for _, ch := range changes {
+			if ch.Seq <= last {
+				return fmt.Errorf("change %d is out of order after %d", ch.Seq, last)
+			}
+			var err error
+			if ch.New != nil {
+				err = tx.Put(*ch.New)
+			} else {
+				err = tx.Delete(ch.Item)
+			}
+			if err != nil {
+				return fmt.Errorf("applying change %d: %v", ch.Seq, err)
+			}
+			v, err := json.Marshal(ch)
+			if err != nil {
+				return err
+			}
+			if err := tx.Bucket(historyBucket).Put(Uint64ToBytes(ch.Seq), v); err != nil {
+				return err
+			}
+			if err := tx.Bucket(historyIndexBucket).Put(historyIndexKey(ch.Item, ch.Seq), nil); err != nil {
+				return err
+			}
+			last = ch.Seq
+		}
+		return nil
+	})
+	if err == nil {
+		r.s.committed(changes)
+	}
+	return err
+}
+
+// status reports the follower's position
+func (r *replica) status() replicaStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st := replicaStatus{Role: "follower", Leader: r.leader, AppliedSeq: r.applied, LeaderSeq: r.leaderSeq, Error: r.lastErr}
+	if r.leaderSeq > r.applied {
+		st.Behind = r.leaderSeq - r.applied
+	}
+	if !r.lastContact.IsZero() {
+		t := r.lastContact.UTC()
+		st.LastContact = &t
+	}
+	if (st.Behind > 0 || r.lastErr != "") && !r.caughtUp.IsZero() {
+		st.LagSeconds = time.Since(r.caughtUp).Seconds()
+	}
+	return st
+}
+
+// readOnly wraps the handler of a follower, refusing every request that
+// could change the database. Signing in and out of the dashboard only
+// changes sessions, so it is allowed.
+func (s *server) readOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		safe := req.Method == http.MethodGet || req.Method == http.MethodHead || req.Method == http.MethodOptions ||
+			req.URL.Path == "/ui/login" || req.URL.Path == "/ui/logout"
+		if !safe || req.URL.Path == "/update" || req.URL.Path == "/delete" {
+			writeError(w, http.StatusForbidden, "this server is a read-only follower of %s", s.replica.leader)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// Tail the change log: GET /replication/log
+func (s *server) replicationLog(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	var after uint64
+	if a := q.Get("after"); a != "" {
+		var err error
+		if after, err = strconv.ParseUint(a, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, "after must be a sequence number")
+			return
+		}
+	}
+	limit, err := intParam(q.Get("limit"), replicaBatch, 1, maxLogLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "limit: %v", err)
+		return
+	}
+	wait, err := intParam(q.Get("wait"), 0, 0, maxLogWait)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "wait: %v", err)
+		return
+	}
+
+	var timeout <-chan time.Time
+	if wait > 0 {
+		t := time.NewTimer(time.Duration(wait) * time.Second)
+		defer t.Stop()
+		timeout = t.C
+	}
+	cl := changeLog{Changes: []change{}}
+	for {
+		ready := s.feed.wait()
+		if err := s.store.Tx(false, func(tx StoreTx) (err error) {
+			cl.LastSeq, cl.Generation = lastSeq(tx), generationOf(tx)
+			if cl.Changes, err = changesAfter(tx, after, limit); cl.Changes == nil {
+				cl.Changes = []change{}
+			}
+			if err != nil || !q.Has("keys") {
+				return err
+			}
+			keys, err := listKeys(tx)
+			if err != nil {
+				return err
+			}
+			if digest, err := keysDigest(keys); err != nil || digest != q.Get("keys") {
+				cl.Keys = keys
+				return err
+			}
+			return nil
+		}); err != nil {
+			writeError(w, http.StatusInternalServerError, "could not read history: %v", err)
+			return
+		}
+		if len(cl.Changes) > 0 || cl.Keys != nil || timeout == nil {
+			break
+		}
+		select {
+		case <-ready:
+			continue
+		case <-timeout:
+		case <-s.feed.stopped():
+		case <-req.Context().Done():
+			return
+		}
+		break
+	}
+	writeJSON(w, http.StatusOK, cl)
+}
+
+// Report replication state: GET /replication/status
+func (s *server) replicationStatus(w http.ResponseWriter, req *http.Request) {
+	if s.replica != nil {
+		writeJSON(w, http.StatusOK, s.replica.status())
+		return
+	}
+	st := replicaStatus{Role: "leader"}
+	if err := s.store.Tx(false, func(tx StoreTx) error {
+		st.AppliedSeq = lastSeq(tx)
+		return nil
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, "could not read history: %v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startFollower returns a server following the leader at url, replicating
+// until the test ends
+func startFollower(t *testing.T, url, key string) *server {
+	t.Helper()
+	follower, err := newServer(openTestBolt(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := newReplica(follower, url, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return follower
+}
+
+// eventually waits for h to answer GET path with code and a body
+// containing want
+func eventually(t *testing.T, h http.Handler, key, path string, code int, want string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		got, body := send(h, key, "GET", path, "")
+		if got == code && strings.Contains(body, want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("GET %s: %d %s; want %d containing %q", path, got, body, code, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestReplicationLoopback runs a leader and a follower in one process and
+// checks that item changes and key revocations reach the follower, which
+// refuses writes
+func TestReplicationLoopback(t *testing.T) {
+	leader, key := newTestServer(t, openTestBolt(t))
+	ts := httptest.NewServer(leader.handler())
+	t.Cleanup(ts.Close) // after the follower stops polling
+	lh := leader.handler()
+	send(lh, key, "PUT", "/items/socks", `{"price": 1}`) // copied, not replayed
+
+	follower := startFollower(t, ts.URL, key)
+	fh := follower.handler()
+	eventually(t, fh, key, "/items/socks", http.StatusOK, `"price":1`)
+
+	if code, body := send(lh, key, "PUT", "/items/hats", `{"price": 3}`); code != http.StatusCreated {
+		t.Fatalf("create on leader: %d %s", code, body)
+	}
+	eventually(t, fh, key, "/items/hats", http.StatusOK, `"price":3`)
+	send(lh, key, "DELETE", "/items/socks", "")
+	eventually(t, fh, key, "/items/socks", http.StatusNotFound, "no such item")
+	eventually(t, fh, key, "/items/socks/history", http.StatusOK, `"op":"delete"`)
+	eventually(t, fh, key, "/replication/status", http.StatusOK, `"applied_seq":3`)
+
+	for _, c := range []struct{ method, path string }{
+		{"PUT", "/items/hats"}, {"DELETE", "/items/hats"}, {"GET", "/update?item=hats&price=4"},
+	} {
+		if code, _ := send(fh, key, c.method, c.path, `{"price": 4}`); code != http.StatusForbidden {
+			t.Errorf("%s %s on follower: %d; want %d", c.method, c.path, code, http.StatusForbidden)
+		}
+	}
+
+	k, reader, err := leader.issueKey("till-1", roleReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, fh, reader, "/items/hats", http.StatusOK, "hats")
+	if _, err := leader.revokeKey(k.ID); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := send(lh, reader, "GET", "/items/hats", ""); code != http.StatusUnauthorized {
+		t.Errorf("revoked key on leader: %d; want %d", code, http.StatusUnauthorized)
+	}
+	eventually(t, fh, reader, "/items/hats", http.StatusUnauthorized, "revoked")
+}
+
+// TestReplicationLeaderRestored checks that a follower copies the leader
+// again when the leader is restored, even once the leader's log has grown
+// past the follower's position again
+func TestReplicationLeaderRestored(t *testing.T) {
+	leader, key := newTestServer(t, openTestBolt(t))
+	lh := leader.handler()
+	ts := httptest.NewServer(lh)
+	t.Cleanup(ts.Close) // after the follower stops polling
+
+	send(lh, key, "PUT", "/items/hats", `{"price": 1}`)
+	code, snapshot := send(lh, key, "GET", "/admin/backup", "")
+	if code != http.StatusOK {
+		t.Fatalf("backup: %d", code)
+	}
+	send(lh, key, "PUT", "/items/socks", `{"price": 2}`)
+	send(lh, key, "PUT", "/items/shirts", `{"price": 3}`) // seq 3
+
+	fh := startFollower(t, ts.URL, key).handler()
+	eventually(t, fh, key, "/items/shirts", http.StatusOK, "shirts")
+
+	if code, body := send(lh, key, "POST", "/admin/restore", snapshot); code != http.StatusOK {
+		t.Fatalf("restore: %d %s", code, body)
+	}
+	for _, name := range []string{"gloves", "scarves", "boots"} { // seq 2 to 4
+		send(lh, key, "PUT", "/items/"+name, `{"price": 4}`)
+	}
+	eventually(t, fh, key, "/items/boots", http.StatusOK, "boots")
+	for _, name := range []string{"socks", "shirts"} {
+		if code, _ := send(fh, key, "GET", "/items/"+name, ""); code != http.StatusNotFound {
+			t.Errorf("follower still has %s: %d", name, code)
+		}
+	}
+	if _, body := send(fh, key, "GET", "/list", ""); body != "boots: $4.00\ngloves: $4.00\nhats: $1.00\nscarves: $4.00\n" {
+		t.Errorf("follower list: %q", body)
+	}
+}
+
+// TestReplicationLog checks the paging of the leader's change log
+func TestReplicationLog(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	h := s.handler()
+	for _, name := range []string{"hats", "socks", "shirts"} {
+		send(h, key, "PUT", "/items/"+name, `{"price": 1}`)
+	}
+	for _, c := range []struct {
+		query string
+		code  int
+		want  string
+	}{
+		{"after=1&limit=1", http.StatusOK, `"seq":2,`},
+		{"after=3&wait=0", http.StatusOK, `{"changes":[],"last_seq":3,"generation":`},
+		{"after=x", http.StatusBadRequest, "after must be"},
+		{"limit=0", http.StatusBadRequest, "limit"},
+		{"wait=61", http.StatusBadRequest, "wait"},
+	} {
+		if code, body := send(h, key, "GET", "/replication/log?"+c.query, ""); code != c.code || !strings.Contains(body, c.want) {
+			t.Errorf("log %s: %d %s; want %d containing %q", c.query, code, body, c.code, c.want)
+		}
+	}
+	if code, body := send(h, key, "GET", "/replication/status", ""); !strings.Contains(body, `"role":"leader"`) {
+		t.Errorf("status of a leader: %d %s", code, body)
+	}
+}
@@ -0,0 +1,233 @@
+/* Inventory reports.
+GET /reports/valuation          units on hand and their value, per category
+GET /reports/prices             min/max/mean/median price per category
+GET /reports/low-stock          items at or below their reorder_level, or
+	at or below ?threshold=N when given
+GET /reports/stale?days=N       items not changed in the last N days
+Every report takes the filters of /list (prefix, q, min, max, category,
+tag; see list.go) to narrow the items it covers. Each is computed from one
+read-only transaction, so its figures are consistent with each other.
+Categories are reported as stored: items in clothing/shirts are not
+counted under clothing, and "" holds the items without a category. */
+
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// maxStaleDays bounds the days parameter of /reports/stale
+const maxStaleDays = 36500
+
+// errValueOverflow means a valuation does not fit in dollars
+var errValueOverflow = errors.New("inventory value too large to report")
+
+// valuation totals the stock of a set of items
+type valuation struct {
+	Category string  `json:"category"`
+	Items    int     `json:"items"`
+	Units    int64   `json:"units"`
+	Value    dollars `json:"value"` // sum of price * quantity
+}
+
+// add counts it, failing if the value overflows
+func (v *valuation) add(it item) error {
+	x := it.Price.Mul(it.Quantity) // both bounded by validate, so no overflow
+	if x > 0 && v.Value > math.MaxInt64-x {
+		return errValueOverflow
+	}
+	v.Items++
+	v.Units += it.Quantity
+	v.Value += x
+	return nil
+}
+
+// priceStats summarizes the prices of a set of items
+type priceStats struct {
+	Category string  `json:"category"`
+	Items    int     `json:"items"`
+	Min      dollars `json:"min"`
+	Max      dollars `json:"max"`
+	Mean     dollars `json:"mean"`   // rounded to the cent
+	Median   dollars `json:"median"` // mean of the middle two for an even count
+}
+
+// newPriceStats summarizes prices, which must not be empty
+func newPriceStats(category string, prices []dollars) priceStats {
+	sort.Slice(prices, func(i, j int) bool { return prices[i] < prices[j] })
+	n := len(prices)
+	var sum int64 // prices are at most maxPrice, so this cannot overflow for any realistic n
+	for _, p := range prices {
+		sum += int64(p)
+	}
+	st := priceStats{Category: category, Items: n, Min: prices[0], Max: prices[n-1]}
+	st.Mean = dollars((sum + int64(n)/2) / int64(n))
+	if st.Median = prices[n/2]; n%2 == 0 {
+		st.Median = (prices[n/2-1] + prices[n/2] + 1) / 2
+	}
+	return st
+}
+
+// reportScope parses the filters of a report request. Reports cover every
+// matching item, so paging parameters are ignored.
+func reportScope(w http.ResponseWriter, req *http.Request) (lq listQuery, ok bool) {
+	lq, err := parseListQuery(req.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return lq, false
+	}
+	lq.Sort, lq.Desc, lq.Limit, lq.Cursor = "name", false, 0, nil
+	return lq, true
+}
+
+// scan calls fn with every item matching lq, in name order
+func (s *server) scan(lq listQuery, fn func(it item) error) error {
+	return s.store.Tx(false, func(tx StoreTx) error {
+		return scanTx(tx, lq, func(_ []byte, it item) (bool, error) {
+			return true, fn(it)
+		})
+	})
+}
+
+// Inventory value: GET /reports/valuation
+func (s *server) valuationReport(w http.ResponseWriter, req *http.Request) {
+	lq, ok := reportScope(w, req)
+	if !ok {
+		return
+	}
+	total := valuation{}
+	byCategory := make(map[string]*valuation)
+	err := s.scan(lq, func(it item) error {
+		c := byCategory[it.Category]
+		if c == nil {
+			c = &valuation{Category: it.Category}
+			byCategory[it.Category] = c
+		}
+		if err := c.add(it); err != nil {
+			return err
+		}
+		return total.add(it)
+	})
+	if err != nil {
+		writeReportError(w, err)
+		return
+	}
+	list := []valuation{}
+	for _, c := range byCategory {
+		list = append(list, *c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Category < list[j].Category })
+	writeJSON(w, http.StatusOK, struct {
+		Items      int         `json:"items"`
+		Units      int64       `json:"units"`
+		Value      dollars     `json:"value"`
+		Categories []valuation `json:"categories"`
+	}{total.Items, total.Units, total.Value, list})
+}
+
+// Price statistics: GET /reports/prices
+func (s *server) pricesReport(w http.ResponseWriter, req *http.Request) {
+	lq, ok := reportScope(w, req)
+	if !ok {
+		return
+	}
+	var all []dollars
+	byCategory := make(map[string][]dollars)
+	err := s.scan(lq, func(it item) error {
+		all = append(all, it.Price)
+		byCategory[it.Category] = append(byCategory[it.Category], it.Price)
+		return nil
+	})
+	if err != nil {
+		writeReportError(w, err)
+		return
+	}
+	list := []priceStats{}
+	for c, prices := range byCategory {
+		list = append(list, newPriceStats(c, prices))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Category < list[j].Category })
+	var overall *priceStats
+	if len(all) > 0 {
+		st := newPriceStats("", all)
+		overall = &st
+	}
+	writeJSON(w, http.StatusOK, struct {
+		All        *priceStats  `json:"all"` // null when no item matches
+		Categories []priceStats `json:"categories"`
+	}{overall, list})
+}
+
+// Items to reorder: GET /reports/low-stock
+func (s *server) lowStockReport(w http.ResponseWriter, req *http.Request) {
+	lq, ok := reportScope(w, req)
+	if !ok {
+		return
+	}
+	threshold := int64(-1)
+	if t := req.URL.Query().Get("threshold"); t != "" {
+		n, err := intParam(t, 0, 0, maxQuantity)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "threshold: %v", err)
+			return
+		}
+		threshold = int64(n)
+	}
+	items := []item{}
+	err := s.scan(lq, func(it item) error {
+		if threshold >= 0 && it.Quantity <= threshold || threshold < 0 && it.lowStock() {
+			items = append(items, it)
+		}
+		return nil
+	})
+	if err != nil {
+		writeReportError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Items []item `json:"items"`
+	}{items})
+}
+
+// Items not changed lately: GET /reports/stale?days=N
+func (s *server) staleReport(w http.ResponseWriter, req *http.Request) {
+	lq, ok := reportScope(w, req)
+	if !ok {
+		return
+	}
+	days, err := intParam(req.URL.Query().Get("days"), 0, 1, maxStaleDays)
+	if err != nil || days == 0 {
+		writeError(w, http.StatusBadRequest, "days must be an integer between 1 and %d", maxStaleDays)
+		return
+	}
+	since := time.Now().UTC().AddDate(0, 0, -days)
+	items := []item{}
+	err = s.scan(lq, func(it item) error {
+		if it.Updated.Before(since) {
+			items = append(items, it)
+		}
+		return nil
+	})
+	if err != nil {
+		writeReportError(w, err)
+		return
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Updated.Before(items[j].Updated) })
+	writeJSON(w, http.StatusOK, struct {
+		Since time.Time `json:"unchanged_since"`
+		Items []item    `json:"items"` // least recently changed first
+	}{since, items})
+}
+
+// writeReportError reports a failed report
+func writeReportError(w http.ResponseWriter, err error) {
+	if err == errValueOverflow {
+		writeError(w, http.StatusUnprocessableEntity, "%v; narrow it with category or other filters", err)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, "could not read database: %v", err)
+}
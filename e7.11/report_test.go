@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestReports checks the figures of each report over a small inventory
+func TestReports(t *testing.T) {
+	st := newMemStore()
+	s, key := newTestServer(t, st)
+	h := s.handler()
+	for name, body := range map[string]string{
+		"hats":  `{"price": 10, "quantity": 3, "reorder_level": 5, "category": "clothing"}`,
+		"socks": `{"price": 2.50, "quantity": 10, "reorder_level": 2, "category": "clothing"}`,
+		"mugs":  `{"price": 4}`,
+	} {
+		if code, resp := send(h, key, "PUT", "/items/"+name, body); code != http.StatusCreated {
+			t.Fatalf("create %s: %d %s", name, code, resp)
+		}
+	}
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := st.Put(item{Name: "vases", Price: 100, Unit: defaultUnit, Created: old, Updated: old, Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(path string, v interface{}) {
+		t.Helper()
+		code, body := send(h, key, "GET", path, "")
+		if code != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", path, code, body)
+		}
+		if err := json.Unmarshal([]byte(body), v); err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+	}
+	names := func(items []item) (list []string) {
+		for _, it := range items {
+			list = append(list, it.Name)
+		}
+		return list
+	}
+
+	var val struct {
+		Items      int         `json:"items"`
+		Units      int64       `json:"units"`
+		Value      dollars     `json:"value"`
+		Categories []valuation `json:"categories"`
+	}
+	get("/reports/valuation", &val)
+	if val.Items != 4 || val.Units != 13 || val.Value != 5500 || len(val.Categories) != 2 ||
+		val.Categories[1] != (valuation{"clothing", 2, 13, 5500}) {
+		t.Errorf("valuation: %+v", val)
+	}
+
+	var prices struct {
+		All        *priceStats  `json:"all"`
+		Categories []priceStats `json:"categories"`
+	}
+	get("/reports/prices?category=clothing", &prices)
+	if prices.All == nil || *prices.All != (priceStats{"", 2, 250, 1000, 625, 625}) {
+		t.Errorf("prices in clothing: %+v", prices.All)
+	}
+	get("/reports/prices", &prices)
+	if *prices.All != (priceStats{"", 4, 100, 1000, 438, 325}) {
+		t.Errorf("all prices: %+v", prices.All)
+	}
+	get("/reports/prices?prefix=none", &prices)
+	if prices.All != nil || len(prices.Categories) != 0 {
+		t.Errorf("prices of nothing: %+v", prices)
+	}
+
+	var low struct {
+		Items []item `json:"items"`
+	}
+	get("/reports/low-stock", &low)
+	if got := names(low.Items); len(got) != 1 || got[0] != "hats" {
+		t.Errorf("low stock: %v; want hats", got)
+	}
+	get("/reports/low-stock?threshold=3", &low)
+	if got := names(low.Items); len(got) != 3 {
+		t.Errorf("3 or fewer on hand: %v; want hats, mugs and vases", got)
+	}
+
+	var stale struct {
+		Items []item `json:"items"`
+	}
+	get("/reports/stale?days=30", &stale)
+	if got := names(stale.Items); len(got) != 1 || got[0] != "vases" {
+		t.Errorf("stale: %v; want vases", got)
+	}
+	for _, path := range []string{"/reports/stale", "/reports/stale?days=0", "/reports/low-stock?threshold=-1", "/reports/prices?min=x"} {
+		if code, body := send(h, key, "GET", path, ""); code != http.StatusBadRequest {
+			t.Errorf("GET %s: %d %s; want %d", path, code, body, http.StatusBadRequest)
+		}
+	}
+}
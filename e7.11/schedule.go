@@ -0,0 +1,461 @@
+/* Scheduled price changes.
+POST   /items/{name}/schedules  schedule a price ({"price": 9.99,
+	"start": "2024-07-01T00:00:00-07:00", "end": "2024-07-08T00:00:00-07:00"})
+GET    /items/{name}/schedules  schedules of one item
+GET    /schedules?status=pending  all schedules, oldest first
+GET    /schedules/{id}          one schedule
+DELETE /schedules/{id}          cancel a pending or active schedule
+At start the price is set and the replaced price remembered; at end, if
+given, it is restored, unless the price was changed again in between.
+A cancelled active schedule keeps its price and is never reverted.
+Schedules of one item may not overlap. They are stored in the database and
+applied by a background scheduler, in order of due time, each in the same
+transaction as its bookkeeping; changes that came due while the server was
+down are applied when it starts. */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// schedulesBucket holds id -> schedule records
+var schedulesBucket = []byte("schedules")
+
+// scheduleDueBucket holds due time (8 bytes of Unix nanoseconds) + id ->
+// nil for every schedule with a start or end still to apply
+var scheduleDueBucket = []byte("schedule_due")
+
+// Schedule states
+const (
+	schedPending  = "pending"  // waiting for start
+	schedActive   = "active"   // started; waiting for end
+	schedDone     = "done"     // applied, and reverted if it had an end
+	schedCanceled = "canceled" // cancelled before it finished
+	schedFailed   = "failed"   // could not be applied, see Note
+)
+
+// schedulerActor is recorded in the history for scheduled changes
+const schedulerActor = "scheduler"
+
+// maxSchedulerSleep bounds how long the scheduler sleeps, so it notices a
+// changed clock
+const maxSchedulerSleep = time.Minute
+
+// schedule is a price change for one item over a period
+type schedule struct {
+	ID       uint64     `json:"id"`
+	Item     string     `json:"item"`
+	Price    dollars    `json:"price"`
+	Start    time.Time  `json:"start"`
+	End      *time.Time `json:"end,omitempty"`
+	Status   string     `json:"status"`
+	Previous *dollars   `json:"previous_price,omitempty"` // price replaced at start
+	Applied  *time.Time `json:"applied,omitempty"`
+	Reverted *time.Time `json:"reverted,omitempty"`
+	Note     string     `json:"note,omitempty"`
+	Actor    string     `json:"actor"`
+	Created  time.Time  `json:"created"`
+}
+
+// open reports whether s still has a start or end to apply
+func (sc schedule) open() bool {
+	return sc.Status == schedPending || sc.Status == schedActive
+}
+
+// overlaps reports whether the periods of sc and o intersect. A schedule
+// without an end occupies only its start.
+func (sc schedule) overlaps(o schedule) bool {
+	before := func(a, b schedule) bool {
+		end := a.Start
+		if a.End != nil {
+			end = *a.End
+		}
+		return !end.After(b.Start)
+	}
+	return !before(sc, o) && !before(o, sc)
+}
+
+// errScheduleClosed rejects cancelling a finished schedule
+var errScheduleClosed = errors.New("schedule has already finished")
+
+// errNoSuchSchedule is returned for an unknown schedule id
+var errNoSuchSchedule = errors.New("no such schedule")
+
+// overlapError rejects a schedule that overlaps another of the same item
+type overlapError struct{ id uint64 }
+
+func (e overlapError) Error() string {
+	return fmt.Sprintf("overlaps schedule %d of the same item", e.id)
+}
+
+// maxScheduleTime is the latest start or end a schedule may have: the
+// due index keys times by their Unix nanoseconds
+var maxScheduleTime = time.Unix(0, math.MaxInt64).UTC()
+
+// dueKey returns the due index key of schedule id at t
+func dueKey(t time.Time, id uint64) []byte {
+	return append(Uint64ToBytes(uint64(t.UnixNano())), Uint64ToBytes(id)...)
+}
+
+// getSchedule reads schedule id
+func getSchedule(tx StoreTx, id uint64) (sc schedule, ok bool, err error) {
+	v := tx.Bucket(schedulesBucket).Get(Uint64ToBytes(id))
+	if v == nil {
+		return sc, false, nil
+	}
+	err = json.Unmarshal(v, &sc)
+	return sc, err == nil, err
+}
+
+// putSchedule stores sc
+func putSchedule(tx StoreTx, sc schedule) error {
+	v, err := json.Marshal(sc)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(schedulesBucket).Put(Uint64ToBytes(sc.ID), v)
+}
+
+// listSchedules reads the schedules accepted by keep, oldest first
+func listSchedules(tx StoreTx, keep func(sc schedule) bool) ([]schedule, error) {
+	list := []schedule{}
+	err := tx.Bucket(schedulesBucket).ForEach(func(k, v []byte) error {
+		var sc schedule
+		if err := json.Unmarshal(v, &sc); err != nil {
+			return fmt.Errorf("schedule %d: %v", BytesToUint64(k), err)
+		}
+		if keep(sc) {
+			list = append(list, sc)
+		}
+		return nil
+	})
+	return list, err
+}
+
+// addSchedule stores a new schedule for sc.Item on behalf of actor
+func (s *server) addSchedule(actor string, sc schedule) (schedule, error) {
+	if err := checkPrice(sc.Price); err != nil {
+		return sc, invalidError{err}
+	}
+	now := time.Now().UTC()
+	if sc.Start.Before(now) {
+		sc.Start = now // due at once
+	}
+	sc.Start = sc.Start.UTC()
+	if sc.Start.After(maxScheduleTime) || sc.End != nil && sc.End.After(maxScheduleTime) {
+		return sc, invalidf("start and end must be no later than %s", maxScheduleTime.Format(time.RFC3339))
+	}
+	if sc.End != nil {
+		end := sc.End.UTC()
+		if !end.After(sc.Start) {
+			return sc, invalidf("end must be after start")
+		}
+		sc.End = &end
+	}
+	sc.Status, sc.Actor, sc.Created = schedPending, actor, now
+
+	err := s.store.Tx(true, func(tx StoreTx) error {
+		if _, ok, err := tx.Get(sc.Item); err != nil || !ok {
+			if err == nil {
+				err = errNoSuchItem
+			}
+			return err
+		}
+		others, err := listSchedules(tx, func(o schedule) bool { return o.Item == sc.Item && o.open() })
+		if err != nil {
+			return err
+		}
+		for _, o := range others {
+			if sc.overlaps(o) {
+				return overlapError{o.ID}
+			}
+		}
+		if sc.ID, err = tx.Bucket(schedulesBucket).NextSequence(); err != nil {
+			return err
+		}
+		if err := putSchedule(tx, sc); err != nil {
+			return err
+		}
+		return tx.Bucket(scheduleDueBucket).Put(dueKey(sc.Start, sc.ID), nil)
+	})
+	if err == nil {
+		s.wakeScheduler()
+	}
+	return sc, err
+}
+
+// cancelSchedule stops schedule id from applying anything further
+func (s *server) cancelSchedule(id uint64) (sc schedule, err error) {
+	err = s.store.Tx(true, func(tx StoreTx) error {
+		var ok bool
+		if sc, ok, err = getSchedule(tx, id); err != nil || !ok {
+			if err == nil {
+				err = errNoSuchSchedule
+			}
+			return err
+		}
+		if !sc.open() {
+			return errScheduleClosed
+		}
+		due := sc.Start
+		if sc.Status == schedActive {
+			due = *sc.End
+		}
+		if err := tx.Bucket(scheduleDueBucket).Delete(dueKey(due, id)); err != nil {
+			return err
+		}
+		sc.Status = schedCanceled
+		return putSchedule(tx, sc)
+	})
+	return sc, err
+}
+
+// wakeScheduler makes the scheduler look at the due index again
+func (s *server) wakeScheduler() {
+	select {
+	case s.schedWake <- struct{}{}:
+	default: // already woken
+	}
+}
+
+// runScheduler applies schedules as they come due until ctx is done
+func (s *server) runScheduler(ctx context.Context) {
+	for {
+		next, err := s.applyDueSchedules(time.Now())
+		delay := maxSchedulerSleep
+		if err != nil {
+			log.Printf("scheduler: %v", err)
+			delay = 5 * time.Second
+		} else if !next.IsZero() {
+			if d := time.Until(next); d < delay {
+				delay = d
+			}
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-s.schedWake:
+			t.Stop()
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+	}
+}
+
+// applyDueSchedules applies every start and end due at or before now in
+// one transaction and returns when the next one is due, or zero if none is
+func (s *server) applyDueSchedules(now time.Time) (next time.Time, err error) {
+	err = s.write(schedulerActor, func(w *writeTx) error {
+		due := w.tx.Bucket(scheduleDueBucket)
+		var keys [][]byte
+		c := due.Cursor()
+		for k, _ := c.First(); k != nil && int64(BytesToUint64(k[:8])) <= now.UnixNano(); k, _ = c.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		for _, k := range keys {
+			if err := due.Delete(k); err != nil {
+				return err
+			}
+			if err := w.fireSchedule(BytesToUint64(k[8:])); err != nil {
+				return err
+			}
+		}
+		if k, _ := due.Cursor().First(); k != nil {
+			next = time.Unix(0, int64(BytesToUint64(k[:8])))
+		}
+		return nil
+	})
+	return next, err
+}
+
+// fireSchedule applies the start or end of schedule id that has come due
+func (w *writeTx) fireSchedule(id uint64) error {
+	sc, ok, err := getSchedule(w.tx, id)
+	if err != nil || !ok {
+		return err // a due entry without a schedule is dropped
+	}
+	it, exists, err := w.get(sc.Item)
+	if err != nil {
+		return err
+	}
+	now := w.now
+
+	switch sc.Status {
+	case schedPending:
+		if !exists {
+			sc.Status, sc.Note = schedFailed, "item no longer exists"
+			break
+		}
+		prev := it.Price
+		it.Price = sc.Price
+		if err := w.put(it); err != nil {
+			if _, invalid := err.(invalidError); !invalid {
+				return err
+			}
+			sc.Status, sc.Note = schedFailed, err.Error()
+			break
+		}
+		sc.Previous, sc.Applied, sc.Status = &prev, &now, schedDone
+		if sc.End != nil {
+			sc.Status = schedActive
+			if err := w.tx.Bucket(scheduleDueBucket).Put(dueKey(*sc.End, sc.ID), nil); err != nil {
+				return err
+			}
+		}
+
+	case schedActive:
+		sc.Status = schedDone
+		switch {
+		case !exists:
+			sc.Note = "item no longer exists; nothing to revert"
+		case it.Price != sc.Price:
+			sc.Note = "price changed since start; not reverted"
+		default:
+			it.Price = *sc.Previous
+			if err := w.put(it); err != nil {
+				if _, invalid := err.(invalidError); !invalid {
+					return err
+				}
+				sc.Status, sc.Note = schedFailed, "could not revert: "+err.Error()
+				break
+			}
+			sc.Reverted = &now
+		}
+
+	default:
+		return nil // cancelled or finished meanwhile
+	}
+	return putSchedule(w.tx, sc)
+}
+
+// List the price changes of one item: GET /items/{name}/schedules
+func (s *server) itemSchedules(w http.ResponseWriter, req *http.Request) {
+	name := itemName(req)
+	var list []schedule
+	if err := s.store.Tx(false, func(tx StoreTx) (err error) {
+		list, err = listSchedules(tx, func(sc schedule) bool { return sc.Item == name })
+		return err
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, "could not read schedules: %v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Schedules []schedule `json:"schedules"`
+	}{list})
+}
+
+// Schedule a price change for one item: POST /items/{name}/schedules
+func (s *server) addItemSchedule(w http.ResponseWriter, req *http.Request) {
+	name := itemName(req)
+	var in struct {
+		Price *dollars   `json:"price"`
+		Start *time.Time `json:"start"`
+		End   *time.Time `json:"end"`
+	}
+	if err := decodeJSON(w, req, &in); err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	if in.Price == nil || in.Start == nil {
+		writeError(w, http.StatusUnprocessableEntity, "price and start are required")
+		return
+	}
+	sc, err := s.addSchedule(actorOf(req), schedule{Item: name, Price: *in.Price, Start: *in.Start, End: in.End})
+	switch err.(type) {
+	case nil:
+		writeJSON(w, http.StatusCreated, sc)
+	case overlapError:
+		writeError(w, http.StatusConflict, "%v", err)
+	default:
+		if err == errNoSuchItem {
+			writeError(w, http.StatusNotFound, "no such item: %q", name)
+			return
+		}
+		writeStoreError(w, err)
+	}
+}
+
+// List all schedules: GET /schedules
+func (s *server) schedules(w http.ResponseWriter, req *http.Request) {
+	status := req.URL.Query().Get("status")
+	switch status {
+	case "", schedPending, schedActive, schedDone, schedCanceled, schedFailed:
+	default:
+		writeError(w, http.StatusBadRequest, "unknown status %q", status)
+		return
+	}
+	var list []schedule
+	if err := s.store.Tx(false, func(tx StoreTx) (err error) {
+		list, err = listSchedules(tx, func(sc schedule) bool { return status == "" || sc.Status == status })
+		return err
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, "could not read schedules: %v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Schedules []schedule `json:"schedules"`
+	}{list})
+}
+
+// Read a schedule: GET /schedules/{id}
+func (s *server) scheduleByID(w http.ResponseWriter, req *http.Request) {
+	id, ok := scheduleID(w, req)
+	if !ok {
+		return
+	}
+	var sc schedule
+	err := s.store.Tx(false, func(tx StoreTx) (err error) {
+		sc, ok, err = getSchedule(tx, id)
+		return err
+	})
+	if err == nil && !ok {
+		err = errNoSuchSchedule
+	}
+	writeScheduleResult(w, id, sc, err)
+}
+
+// Cancel a schedule: DELETE /schedules/{id}
+func (s *server) deleteSchedule(w http.ResponseWriter, req *http.Request) {
+	id, ok := scheduleID(w, req)
+	if !ok {
+		return
+	}
+	sc, err := s.cancelSchedule(id)
+	writeScheduleResult(w, id, sc, err)
+}
+
+// scheduleID parses the {id} of a /schedules/{id} route, answering 404 if
+// it is not a number
+func scheduleID(w http.ResponseWriter, req *http.Request) (uint64, bool) {
+	id, err := strconv.ParseUint(req.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "not found: %s", req.URL.Path)
+		return 0, false
+	}
+	return id, true
+}
+
+// writeScheduleResult sends sc, or the error that prevented reading or
+// cancelling schedule id
+func writeScheduleResult(w http.ResponseWriter, id uint64, sc schedule, err error) {
+	switch err {
+	case nil:
+		writeJSON(w, http.StatusOK, sc)
+	case errNoSuchSchedule:
+		writeError(w, http.StatusNotFound, "no such schedule: %d", id)
+	case errScheduleClosed:
+		writeError(w, http.StatusConflict, "%v", err)
+	default:
+		writeError(w, http.StatusInternalServerError, "data store unsuccessful: %v", err)
+	}
+}
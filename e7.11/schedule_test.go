@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSchedules runs a price change with an end through its life, and
+// checks overlaps, cancellation and the reasons a schedule is refused
+func TestSchedules(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	h := s.handler()
+	send(h, key, "PUT", "/items/hats", `{"price": 1}`)
+	now := time.Now().UTC()
+	at := func(d time.Duration) string { return now.Add(d).Format(time.RFC3339) }
+	price := func(want string) {
+		t.Helper()
+		if code, body := send(h, key, "GET", "/items/hats", ""); !strings.Contains(body, `"price":`+want) {
+			t.Errorf("hats: %d %s; want price %s", code, body, want)
+		}
+	}
+
+	for _, c := range []struct {
+		method, path, body string
+		code               int
+		want               string
+	}{
+		{"POST", "/items/hats/schedules", fmt.Sprintf(`{"price": 2, "start": %q, "end": %q}`, at(-time.Hour), at(time.Hour)), http.StatusCreated, `"id":1,`},
+		{"POST", "/items/hats/schedules", fmt.Sprintf(`{"price": 3, "start": %q}`, at(30*time.Minute)), http.StatusConflict, "overlaps schedule 1"},
+		{"POST", "/items/hats/schedules", fmt.Sprintf(`{"price": 5, "start": %q}`, at(3*time.Hour)), http.StatusCreated, `"id":2,`},
+		{"POST", "/items/hats/schedules", fmt.Sprintf(`{"price": 5, "start": %q, "end": %q}`, at(5*time.Hour), at(4*time.Hour)), http.StatusUnprocessableEntity, "end must be after start"},
+		{"POST", "/items/hats/schedules", fmt.Sprintf(`{"price": -5, "start": %q}`, at(5*time.Hour)), http.StatusUnprocessableEntity, "price"},
+		{"POST", "/items/hats/schedules", `{"price": 5}`, http.StatusUnprocessableEntity, "price and start are required"},
+		{"POST", "/items/gloves/schedules", fmt.Sprintf(`{"price": 5, "start": %q}`, at(5*time.Hour)), http.StatusNotFound, "no such item"},
+		{"GET", "/schedules?status=pending", "", http.StatusOK, `"id":2,`},
+		{"GET", "/schedules?status=late", "", http.StatusBadRequest, "unknown status"},
+		{"GET", "/schedules/9", "", http.StatusNotFound, "no such schedule"},
+	} {
+		if code, body := send(h, key, c.method, c.path, c.body); code != c.code || !strings.Contains(body, c.want) {
+			t.Errorf("%s %s %s: %d %s; want %d containing %q", c.method, c.path, c.body, code, body, c.code, c.want)
+		}
+	}
+
+	apply := func(d time.Duration) {
+		t.Helper()
+		if _, err := s.applyDueSchedules(now.Add(d)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	apply(time.Second)
+	price("2.00")
+	if code, body := send(h, key, "GET", "/schedules/1", ""); !strings.Contains(body, `"status":"active","previous_price":1.00`) {
+		t.Errorf("schedule 1 after start: %d %s", code, body)
+	}
+	apply(2 * time.Hour)
+	price("1.00")
+	if code, body := send(h, key, "GET", "/schedules/1", ""); !strings.Contains(body, `"status":"done"`) || !strings.Contains(body, `"reverted"`) {
+		t.Errorf("schedule 1 after end: %d %s", code, body)
+	}
+
+	if code, body := send(h, key, "DELETE", "/schedules/2", ""); code != http.StatusOK || !strings.Contains(body, `"status":"canceled"`) {
+		t.Errorf("cancel: %d %s", code, body)
+	}
+	if code, _ := send(h, key, "DELETE", "/schedules/2", ""); code != http.StatusConflict {
+		t.Errorf("cancel twice: %d; want %d", code, http.StatusConflict)
+	}
+	apply(4 * time.Hour)
+	price("1.00")
+	if code, body := send(h, key, "GET", "/items/hats/history", ""); !strings.Contains(body, `"actor":"scheduler"`) {
+		t.Errorf("history: %d %s; want changes by the scheduler", code, body)
+	}
+}
+
+// TestScheduleNotReverted checks that a price changed during a schedule is
+// left alone at its end
+func TestScheduleNotReverted(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	h := s.handler()
+	send(h, key, "PUT", "/items/hats", `{"price": 1}`)
+	now := time.Now().UTC()
+	body := fmt.Sprintf(`{"price": 2, "start": %q, "end": %q}`, now.Format(time.RFC3339), now.Add(time.Hour).Format(time.RFC3339))
+	if code, resp := send(h, key, "POST", "/items/hats/schedules", body); code != http.StatusCreated {
+		t.Fatalf("schedule: %d %s", code, resp)
+	}
+	if _, err := s.applyDueSchedules(now.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	send(h, key, "PUT", "/items/hats", `{"price": 7}`)
+	if _, err := s.applyDueSchedules(now.Add(2 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if code, resp := send(h, key, "GET", "/items/hats", ""); !strings.Contains(resp, `"price":7.00`) {
+		t.Errorf("hats: %d %s; want the price set by hand", code, resp)
+	}
+	if code, resp := send(h, key, "GET", "/schedules/1", ""); !strings.Contains(resp, "not reverted") {
+		t.Errorf("schedule: %d %s", code, resp)
+	}
+}
+
+// TestScheduleFarFuture checks that a schedule too far ahead for the due
+// index is refused rather than applied at once
+func TestScheduleFarFuture(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	h := s.handler()
+	send(h, key, "PUT", "/items/hats", `{"price": 1}`)
+	for _, body := range []string{
+		`{"price": 2, "start": "3000-01-01T00:00:00Z"}`,
+		`{"price": 2, "start": "2030-01-01T00:00:00Z", "end": "3000-01-01T00:00:00Z"}`,
+	} {
+		if code, resp := send(h, key, "POST", "/items/hats/schedules", body); code != http.StatusUnprocessableEntity {
+			t.Errorf("schedule %s: %d %s; want %d", body, code, resp, http.StatusUnprocessableEntity)
+		}
+	}
+	if _, err := s.applyDueSchedules(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if code, body := send(h, key, "GET", "/items/hats", ""); !strings.Contains(body, `"price":1.00`) {
+		t.Errorf("after scheduling: %d %s; want the price unchanged", code, body)
+	}
+}
+
+// TestScheduleRevertFails checks that a schedule whose revert is invalid
+// is marked failed without holding up the others that are due
+func TestScheduleRevertFails(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	h := s.handler()
+	send(h, key, "PUT", "/items/hats", `{"price": 1}`)
+	send(h, key, "PUT", "/items/socks", `{"price": 1}`)
+
+	now := time.Now().UTC()
+	bad := dollars(-1)
+	var ids []uint64
+	if err := s.store.Tx(true, func(tx StoreTx) error {
+		for _, sc := range []schedule{
+			{Item: "hats", Price: 100, Start: now.Add(-time.Hour), End: &now, Status: schedActive, Previous: &bad},
+			{Item: "socks", Price: 300, Start: now, Status: schedPending},
+		} {
+			id, err := tx.Bucket(schedulesBucket).NextSequence()
+			if err != nil {
+				return err
+			}
+			sc.ID, ids = id, append(ids, id)
+			if err := putSchedule(tx, sc); err != nil {
+				return err
+			}
+			if err := tx.Bucket(scheduleDueBucket).Put(dueKey(now, id), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.applyDueSchedules(now); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{`"status":"failed"`, `"status":"done"`} {
+		if code, body := send(h, key, "GET", "/schedules/"+strconv.FormatUint(ids[i], 10), ""); !strings.Contains(body, want) {
+			t.Errorf("schedule %d: %d %s; want %s", ids[i], code, body, want)
+		}
+	}
+	if code, body := send(h, key, "GET", "/items/socks", ""); !strings.Contains(body, `"price":3.00`) {
+		t.Errorf("socks: %d %s; want the scheduled price", code, body)
+	}
+}
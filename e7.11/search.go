@@ -0,0 +1,285 @@
+/* Search for items by name.
+GET /search?q=shrt              best matches for "shrt", best first
+	mode=all|prefix|substring|fuzzy   default all
+	distance=N                        most edits a fuzzy match may need;
+	                                  default 1 per 4 characters, at most 3
+	limit=N                           default 20, at most 100
+Matches are ranked exact, then prefix, then substring, then fuzzy; fuzzy
+matches by edit distance (Levenshtein, counted in characters) to the whole
+name or to one of its words, and ties by name:
+	{"query": "shrt", "results": [{"name": "shirt", "match": "fuzzy",
+	 "distance": 1, "item": {...}}]}
+Prefix matches are read with Cursor.Seek on the inventory bucket. Substring
+and fuzzy matching scan a sorted in-memory list of item names, which is
+built when the server starts and kept up to date as changes commit. */
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits for search
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	maxSearchLen       = 64
+	maxSearchDistance  = 3
+)
+
+// Kinds of match, best first
+const (
+	matchExact     = "exact"
+	matchPrefix    = "prefix"
+	matchSubstring = "substring"
+	matchFuzzy     = "fuzzy"
+)
+
+// matchRank orders kinds of match
+var matchRank = map[string]int{matchExact: 0, matchPrefix: 1, matchSubstring: 2, matchFuzzy: 3}
+
+// searchResult is one item found by a search
+type searchResult struct {
+	Name     string `json:"name"`
+	Match    string `json:"match"`
+	Distance int    `json:"distance"` // edits from the query; 0 unless fuzzy
+	Item     item   `json:"item"`
+}
+
+// nameIndex is the in-memory list of item names used by search. It is
+// updated from the changes of each commit, which may be applied out of
+// commit order, so it remembers the sequence number of the last change
+// applied to each name and skips older ones.
+type nameIndex struct {
+	mu    sync.RWMutex
+	names []string          // sorted
+	floor uint64            // changes up to this sequence are already reflected
+	seen  map[string]uint64 // name -> sequence of the last change applied
+}
+
+// load rebuilds x from the inventory in store
+func (x *nameIndex) load(store InventoryStore) error {
+	var names []string
+	var floor uint64
+	err := store.Tx(false, func(tx StoreTx) error {
+		floor = lastSeq(tx)
+		return tx.Bucket(inventoryBucket).ForEach(func(k, _ []byte) error {
+			names = append(names, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	x.mu.Lock()
+	x.names, x.floor, x.seen = names, floor, make(map[string]uint64)
+	x.mu.Unlock()
+	return nil
+}
+
+// apply records committed changes
+func (x *nameIndex) apply(changes []change) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, c := range changes {
+		if c.Seq <= x.floor || c.Seq <= x.seen[c.Item] {
+			continue
+		}
+		x.seen[c.Item] = c.Seq
+		i := sort.SearchStrings(x.names, c.Item)
+		found := i < len(x.names) && x.names[i] == c.Item
+		switch {
+		case c.New != nil && !found:
+			x.names = append(x.names, "")
+			copy(x.names[i+1:], x.names[i:])
+			x.names[i] = c.Item
+		case c.New == nil && found:
+			x.names = append(x.names[:i], x.names[i+1:]...)
+		}
+	}
+}
+
+// match returns the names that contain q or, if maxDist > 0, are within
+// maxDist edits of it, with the kind of each match
+func (x *nameIndex) match(q string, substring bool, maxDist int) []searchResult {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	var found []searchResult
+	for _, name := range x.names {
+		if substring && strings.Contains(name, q) {
+			found = append(found, searchResult{Name: name, Match: matchSubstring})
+		} else if maxDist > 0 {
+			if d := nameDistance(q, name, maxDist); d <= maxDist {
+				found = append(found, searchResult{Name: name, Match: matchFuzzy, Distance: d})
+			}
+		}
+	}
+	return found
+}
+
+// nameDistance returns the edit distance from q to name or to the closest
+// word of name, or a number above max if both exceed it
+func nameDistance(q, name string, max int) int {
+	best := levenshtein(q, name, max)
+	for _, word := range strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if best == 0 {
+			break
+		}
+		if d := levenshtein(q, word, max); d < best {
+			best = d
+		}
+	}
+	return best
+}
+
+// levenshtein returns the number of single character insertions,
+// deletions and substitutions that turn a into b, or max+1 if that is
+// more than max
+func levenshtein(a, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > max || -d > max {
+		return max + 1
+	}
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if cur[j] < rowMin {
+				rowMin = cur[j]
+			}
+		}
+		if rowMin > max {
+			return max + 1 // every later row is at least as far
+		}
+		prev, cur = cur, prev
+	}
+	if prev[len(rb)] > max {
+		return max + 1
+	}
+	return prev[len(rb)]
+}
+
+// minInt returns the smallest of xs
+func minInt(xs ...int) int {
+	m := xs[0]
+	for _, x := range xs[1:] {
+		if x < m {
+			m = x
+		}
+	}
+	return m
+}
+
+// Search items by name: GET /search?q=...
+func (s *server) search(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	query := canonicalName(q.Get("q"))
+	if query == "" || utf8.RuneCountInString(query) > maxSearchLen {
+		writeError(w, http.StatusBadRequest, "q must be between 1 and %d characters", maxSearchLen)
+		return
+	}
+	mode := q.Get("mode")
+	if mode == "" {
+		mode = "all"
+	}
+	if !contains([]string{"all", matchPrefix, matchSubstring, matchFuzzy}, mode) {
+		writeError(w, http.StatusBadRequest, "mode must be all, prefix, substring or fuzzy")
+		return
+	}
+	limit, err := intParam(q.Get("limit"), defaultSearchLimit, 1, maxSearchLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "limit: %v", err)
+		return
+	}
+	defDist := utf8.RuneCountInString(query) / 4
+	if defDist > maxSearchDistance {
+		defDist = maxSearchDistance
+	}
+	dist, err := intParam(q.Get("distance"), defDist, 0, maxSearchDistance)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "distance: %v", err)
+		return
+	}
+
+	// names from the index; prefix matches come from the store below
+	var found []searchResult
+	switch mode {
+	case "all":
+		found = s.names.match(query, true, dist)
+	case matchSubstring:
+		found = s.names.match(query, true, 0)
+	case matchFuzzy:
+		found = s.names.match(query, false, dist)
+	}
+
+	results := []searchResult{}
+	err = s.store.Tx(false, func(tx StoreTx) error {
+		seen := make(map[string]bool)
+		if mode == "all" || mode == matchPrefix {
+			c := tx.Bucket(inventoryBucket).Cursor()
+			prefix := []byte(query)
+			for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix) && len(results) < limit; k, v = c.Next() {
+				it, err := decodeItem(k, v)
+				if err != nil {
+					return err
+				}
+				m := matchPrefix
+				if it.Name == query {
+					m = matchExact
+				}
+				results = append(results, searchResult{Name: it.Name, Match: m, Item: it})
+				seen[it.Name] = true
+			}
+		}
+		sort.SliceStable(found, func(i, j int) bool {
+			a, b := found[i], found[j]
+			if a.Match != b.Match {
+				return matchRank[a.Match] < matchRank[b.Match]
+			}
+			return a.Distance < b.Distance // names are already sorted
+		})
+		for _, r := range found {
+			if len(results) == limit {
+				break
+			}
+			if seen[r.Name] {
+				continue
+			}
+			it, ok, err := tx.Get(r.Name)
+			if err != nil {
+				return err
+			}
+			if ok { // not deleted since the index was read
+				r.Item = it
+				results = append(results, r)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "could not search: %v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Query   string         `json:"query"`
+		Results []searchResult `json:"results"`
+	}{query, results})
+}
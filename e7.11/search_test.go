@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestSearchRanking checks that exact matches come first, then prefix,
+// substring and fuzzy ones, and that fuzzy matches stop at the distance
+// cutoff
+func TestSearchRanking(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	h := s.handler()
+	for _, name := range []string{"shirt", "shirts", "t-shirt", "skirt", "short", "sort", "bag", "dress shirt"} {
+		if code, body := send(h, key, "PUT", "/items/"+strings.ReplaceAll(name, " ", "%20"), `{"price": 1}`); code != http.StatusCreated {
+			t.Fatalf("create %s: %d %s", name, code, body)
+		}
+	}
+
+	for _, c := range []struct {
+		query string
+		want  string // name:match:distance, best first
+	}{
+		{"q=shirt", "shirt:exact:0 shirts:prefix:0 dress shirt:substring:0 t-shirt:substring:0 short:fuzzy:1 skirt:fuzzy:1"},
+		{"q=shirt&distance=2", "shirt:exact:0 shirts:prefix:0 dress shirt:substring:0 t-shirt:substring:0 short:fuzzy:1 skirt:fuzzy:1 sort:fuzzy:2"},
+		{"q=shirt&mode=prefix", "shirt:exact:0 shirts:prefix:0"},
+		{"q=shirt&mode=fuzzy&distance=1", "dress shirt:fuzzy:0 shirt:fuzzy:0 t-shirt:fuzzy:0 shirts:fuzzy:1 short:fuzzy:1 skirt:fuzzy:1"}, // words count on their own
+		{"q=shrt", "dress shirt:fuzzy:1 shirt:fuzzy:1 short:fuzzy:1 sort:fuzzy:1 t-shirt:fuzzy:1"},
+		{"q=SHIRT&limit=2", "shirt:exact:0 shirts:prefix:0"},
+		{"q=xyz", ""},
+	} {
+		code, body := send(h, key, "GET", "/search?"+c.query, "")
+		var resp struct {
+			Results []searchResult `json:"results"`
+		}
+		if code != http.StatusOK || json.Unmarshal([]byte(body), &resp) != nil {
+			t.Errorf("search %s: %d %s", c.query, code, body)
+			continue
+		}
+		var got []string
+		for _, r := range resp.Results {
+			got = append(got, fmt.Sprintf("%s:%s:%d", r.Name, r.Match, r.Distance))
+		}
+		if strings.Join(got, " ") != c.want {
+			t.Errorf("search %s:\n got %s\nwant %s", c.query, strings.Join(got, " "), c.want)
+		}
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		max  int
+		want int
+	}{
+		{"kitten", "sitting", 3, 3},
+		{"kitten", "sitting", 2, 3}, // cut off at max+1
+		{"shirt", "shirt", 0, 0},
+		{"café", "cafe", 1, 1}, // characters, not bytes
+		{"", "abc", 3, 3},
+		{"abc", "", 1, 2},
+	} {
+		if got := levenshtein(c.a, c.b, c.max); got != c.want {
+			t.Errorf("levenshtein(%q, %q, %d) = %d; want %d", c.a, c.b, c.max, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,367 @@
+/* Stock movements and orders.
+POST /items/{name}/movements  record stock in/out/adjust ({"kind": "in", "quantity": 5, "reason": "delivery"})
+GET  /items/{name}/movements  list movements for an item
+POST /orders                  decrement several items at once ({"lines": [{"item": "shirts", "quantity": 2}]})
+Orders require an Idempotency-Key header; retrying with the same key returns
+the original order instead of decrementing stock again. */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// movementsBucket holds sequence -> movement records
+var movementsBucket = []byte("movements")
+
+// movementIndexBucket holds item name + 0x00 + movement id -> nil, so the
+// movements of one item can be listed without scanning them all
+var movementIndexBucket = []byte("movement_index")
+
+// ordersBucket holds idempotency key -> order records
+var ordersBucket = []byte("orders")
+
+// Kinds of stock movement
+const (
+	stockIn     = "in"     // received stock; quantity > 0
+	stockOut    = "out"    // removed stock; quantity > 0
+	stockAdjust = "adjust" // correction after a count; quantity may be negative
+)
+
+// maxIdempotencyKeyLen bounds the Idempotency-Key header
+const maxIdempotencyKeyLen = 255
+
+// movement is one change to an item's quantity on hand
+type movement struct {
+	ID       uint64    `json:"id"`
+	Item     string    `json:"item"`
+	Kind     string    `json:"kind"`
+	Quantity int64     `json:"quantity"`
+	Balance  int64     `json:"balance"` // quantity on hand after the movement
+	Reason   string    `json:"reason,omitempty"`
+	Order    uint64    `json:"order,omitempty"` // order that caused an "out" movement
+	Time     time.Time `json:"time"`
+}
+
+// orderLine requests quantity units of item
+type orderLine struct {
+	Item     string  `json:"item"`
+	Quantity int64   `json:"quantity"`
+	Price    dollars `json:"price"` // unit price when the order was placed
+}
+
+// order is a set of lines applied in one transaction
+type order struct {
+	ID      uint64      `json:"id"`
+	Key     string      `json:"idempotency_key"`
+	Lines   []orderLine `json:"lines"`
+	Total   dollars     `json:"total"`
+	Created time.Time   `json:"created"`
+}
+
+// shortage describes an order line that cannot be filled
+type shortage struct {
+	Item      string `json:"item"`
+	Requested int64  `json:"requested"`
+	Available int64  `json:"available"`
+}
+
+// shortageError rejects an order when any line lacks stock
+type shortageError struct {
+	Short []shortage
+}
+
+func (e shortageError) Error() string {
+	var names []string
+	for _, s := range e.Short {
+		names = append(names, s.Item)
+	}
+	return "insufficient stock: " + strings.Join(names, ", ")
+}
+
+// List stock movements: GET /items/{name}/movements
+func (s *server) listMovements(w http.ResponseWriter, req *http.Request) {
+	name := itemName(req)
+	list, ok, err := s.itemMovements(name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "could not read database: %v", err)
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "no such item: %q", name)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Movements []movement `json:"movements"`
+	}{list})
+}
+
+// Record a stock movement: POST /items/{name}/movements
+func (s *server) addMovement(w http.ResponseWriter, req *http.Request) {
+	name := itemName(req)
+	var in struct {
+		Kind     string `json:"kind"`
+		Quantity int64  `json:"quantity"`
+		Reason   string `json:"reason"`
+	}
+	if err := decodeJSON(w, req, &in); err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	m, err := s.move(actorOf(req), name, in.Kind, in.Quantity, in.Reason)
+	switch err.(type) {
+	case nil:
+		writeJSON(w, http.StatusCreated, m)
+	case shortageError:
+		writeError(w, http.StatusConflict, "%v", err)
+	case invalidError:
+		writeError(w, http.StatusUnprocessableEntity, "%v", err)
+	default:
+		if err == errNoSuchItem {
+			writeError(w, http.StatusNotFound, "no such item: %q", name)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "data store unsuccessful: %v", err)
+	}
+}
+
+// Place an order: POST /orders
+func (s *server) orders(w http.ResponseWriter, req *http.Request) {
+	key := req.Header.Get("Idempotency-Key")
+	if key == "" || len(key) > maxIdempotencyKeyLen {
+		writeError(w, http.StatusBadRequest, "Idempotency-Key header must be set (at most %d bytes)", maxIdempotencyKeyLen)
+		return
+	}
+	var in struct {
+		Lines []struct {
+			Item     string `json:"item"`
+			Quantity int64  `json:"quantity"`
+		} `json:"lines"`
+	}
+	if err := decodeJSON(w, req, &in); err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	var lines []orderLine
+	for _, l := range in.Lines {
+		lines = append(lines, orderLine{Item: canonicalName(l.Item), Quantity: l.Quantity})
+	}
+
+	o, replayed, err := s.placeOrder(actorOf(req), key, lines)
+	switch err := err.(type) {
+	case nil:
+		status := http.StatusCreated
+		if replayed {
+			status = http.StatusOK
+		}
+		writeJSON(w, status, o)
+	case shortageError:
+		writeJSON(w, http.StatusConflict, struct {
+			apiError
+			Short []shortage `json:"shortages"`
+		}{apiError{http.StatusConflict, err.Error()}, err.Short})
+	case invalidError:
+		writeError(w, http.StatusUnprocessableEntity, "%v", err)
+	default:
+		writeError(w, http.StatusInternalServerError, "order unsuccessful: %v", err)
+	}
+}
+
+// move records a movement of kind against name and updates its quantity
+func (s *server) move(actor, name, kind string, qty int64, reason string) (m movement, err error) {
+	switch kind {
+	case stockIn, stockOut:
+		if qty <= 0 {
+			return m, invalidf("quantity must be greater than 0")
+		}
+	case stockAdjust:
+		if qty == 0 {
+			return m, invalidf("quantity must not be 0")
+		}
+	default:
+		return m, invalidf("kind must be %q, %q or %q", stockIn, stockOut, stockAdjust)
+	}
+	if qty > maxQuantity || qty < -maxQuantity {
+		return m, invalidf("quantity must be at most %d", int64(maxQuantity))
+	}
+	if len(reason) > maxDescriptionLen {
+		return m, invalidf("reason must be at most %d bytes", maxDescriptionLen)
+	}
+
+	err = s.write(actor, func(w *writeTx) error {
+		it, ok, err := w.get(name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errNoSuchItem
+		}
+		delta := qty
+		if kind == stockOut {
+			delta = -qty
+		}
+		if it.Quantity+delta < 0 {
+			return shortageError{[]shortage{{Item: name, Requested: -delta, Available: it.Quantity}}}
+		}
+		m, err = applyMovement(w, it, movement{Kind: kind, Quantity: qty, Reason: reason}, delta)
+		return err
+	})
+	return m, err
+}
+
+// applyMovement changes the quantity of it by delta, stores it and appends
+// m to the movement log within w
+func applyMovement(w *writeTx, it item, m movement, delta int64) (movement, error) {
+	it.Quantity += delta
+	if err := w.put(it); err != nil {
+		return m, err
+	}
+
+	b := w.tx.Bucket(movementsBucket)
+	id, err := b.NextSequence()
+	if err != nil {
+		return m, err
+	}
+	m.ID, m.Item, m.Balance, m.Time = id, it.Name, it.Quantity, w.now
+	v, err := json.Marshal(m)
+	if err != nil {
+		return m, err
+	}
+	if err := b.Put(Uint64ToBytes(id), v); err != nil {
+		return m, err
+	}
+	return m, w.tx.Bucket(movementIndexBucket).Put(movementIndexKey(m.Item, m.ID), nil)
+}
+
+// movementIndexKey returns the movement index key of movement id of name
+func movementIndexKey(name string, id uint64) []byte {
+	return append(append([]byte(name), 0), Uint64ToBytes(id)...)
+}
+
+// itemMovements lists the movements recorded for name, oldest first
+func (s *server) itemMovements(name string) (list []movement, ok bool, err error) {
+	list = []movement{}
+	err = s.store.Tx(false, func(tx StoreTx) error {
+		if _, ok, err = tx.Get(name); err != nil || !ok {
+			return err
+		}
+		b := tx.Bucket(movementsBucket)
+		prefix := append([]byte(name), 0)
+		c := tx.Bucket(movementIndexBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			var m movement
+			if err := json.Unmarshal(b.Get(k[len(prefix):]), &m); err != nil {
+				return err
+			}
+			list = append(list, m)
+		}
+		return nil
+	})
+	return list, ok, err
+}
+
+// placeOrder decrements every line in one transaction. If any item lacks
+// stock nothing is changed. An order already stored under key is returned
+// as is with replayed set, provided the lines match.
+func (s *server) placeOrder(actor, key string, lines []orderLine) (o order, replayed bool, err error) {
+	lines, err = mergeLines(lines)
+	if err != nil {
+		return o, false, err
+	}
+
+	err = s.write(actor, func(w *writeTx) error {
+		orders := w.tx.Bucket(ordersBucket)
+		if v := orders.Get([]byte(key)); v != nil {
+			if err := json.Unmarshal(v, &o); err != nil {
+				return err
+			}
+			if !sameLines(o.Lines, lines) {
+				return invalidf("Idempotency-Key %q was already used for a different order", key)
+			}
+			replayed = true
+			return nil
+		}
+
+		items := make([]item, len(lines))
+		var short []shortage
+		for i, l := range lines {
+			it, ok, err := w.get(l.Item)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return invalidf("no such item: %q", l.Item)
+			}
+			if it.Quantity < l.Quantity {
+				short = append(short, shortage{Item: l.Item, Requested: l.Quantity, Available: it.Quantity})
+			}
+			items[i] = it
+		}
+		if short != nil {
+			return shortageError{short}
+		}
+
+		id, err := orders.NextSequence()
+		if err != nil {
+			return err
+		}
+		o = order{ID: id, Key: key, Lines: lines, Created: w.now}
+		for i, l := range lines {
+			o.Lines[i].Price = items[i].Price
+			o.Total = o.Total.Add(items[i].Price.Mul(l.Quantity))
+			m := movement{Kind: stockOut, Quantity: l.Quantity, Order: id, Reason: "order"}
+			if _, err := applyMovement(w, items[i], m, -l.Quantity); err != nil {
+				return err
+			}
+		}
+		v, err := json.Marshal(o)
+		if err != nil {
+			return err
+		}
+		return orders.Put([]byte(key), v)
+	})
+	return o, replayed, err
+}
+
+// mergeLines validates lines and combines lines for the same item,
+// sorted by item name
+func mergeLines(lines []orderLine) ([]orderLine, error) {
+	if len(lines) == 0 {
+		return nil, invalidf("order must have at least one line")
+	}
+	qty := make(map[string]int64)
+	for _, l := range lines {
+		if l.Item == "" {
+			return nil, invalidf("order line item not set")
+		}
+		if l.Quantity <= 0 || l.Quantity > maxQuantity {
+			return nil, invalidf("order line %q: quantity must be between 1 and %d", l.Item, int64(maxQuantity))
+		}
+		qty[l.Item] += l.Quantity
+	}
+	merged := make([]orderLine, 0, len(qty))
+	for name, q := range qty {
+		merged = append(merged, orderLine{Item: name, Quantity: q})
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Item < merged[j].Item })
+	return merged, nil
+}
+
+// sameLines reports whether a and b request the same quantities,
+// ignoring the recorded prices
+func sameLines(a, b []orderLine) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Item != b[i].Item || a[i].Quantity != b[i].Quantity {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestItemMovements checks that movements change the quantity on hand and
+// that each item lists only its own movements
+func TestItemMovements(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	h := s.handler()
+	for _, name := range []string{"hats", "hatstand", "socks"} {
+		if code, body := send(h, key, "PUT", "/items/"+name, `{"price": 1}`); code != http.StatusCreated {
+			t.Fatalf("create %s: %d %s", name, code, body)
+		}
+	}
+	for i, name := range []string{"hats", "socks", "hatstand", "hats"} {
+		if code, body := send(h, key, "POST", "/items/"+name+"/movements", `{"kind": "in", "quantity": 2}`); code != http.StatusCreated {
+			t.Fatalf("movement %d: %d %s", i, code, body)
+		}
+	}
+
+	code, body := send(h, key, "GET", "/items/hats/movements", "")
+	var got struct{ Movements []movement }
+	if err := json.Unmarshal([]byte(body), &got); code != http.StatusOK || err != nil {
+		t.Fatalf("movements: %d %s", code, body)
+	}
+	if len(got.Movements) != 2 || got.Movements[0].ID != 1 || got.Movements[1].ID != 4 || got.Movements[1].Balance != 4 {
+		t.Errorf("movements of hats: %+v; want ids 1 and 4, balance 4", got.Movements)
+	}
+
+	for _, c := range []struct {
+		method, target, body string
+		code                 int
+		want                 string
+	}{
+		{"POST", "/items/hats/movements", `{"kind": "out", "quantity": 3, "reason": "sold"}`, http.StatusCreated, `"balance":1`},
+		{"POST", "/items/hats/movements", `{"kind": "out", "quantity": 2}`, http.StatusConflict, "insufficient stock"},
+		{"POST", "/items/hats/movements", `{"kind": "adjust", "quantity": -1}`, http.StatusCreated, `"balance":0`},
+		{"POST", "/items/hats/movements", `{"kind": "adjust", "quantity": 0}`, http.StatusUnprocessableEntity, "must not be 0"},
+		{"POST", "/items/hats/movements", `{"kind": "in", "quantity": -1}`, http.StatusUnprocessableEntity, "greater than 0"},
+		{"POST", "/items/hats/movements", `{"kind": "lost", "quantity": 1}`, http.StatusUnprocessableEntity, "kind must be"},
+		{"POST", "/items/gloves/movements", `{"kind": "in", "quantity": 1}`, http.StatusNotFound, "no such item"},
+		{"GET", "/items/gloves/movements", "", http.StatusNotFound, "no such item"},
+		{"DELETE", "/items/hats/movements", "", http.StatusMethodNotAllowed, "method not allowed"},
+		{"GET", "/items/hats", "", http.StatusOK, `"quantity":0`},
+	} {
+		if code, body := send(h, key, c.method, c.target, c.body); code != c.code || !strings.Contains(body, c.want) {
+			t.Errorf("%s %s %s: %d %s; want %d %s", c.method, c.target, c.body, code, body, c.code, c.want)
+		}
+	}
+}
+
+// TestMovementIndex checks that each item lists only its own movements
+// after the index is rebuilt by the migration
+func TestMovementIndex(t *testing.T) {
+	st := openTestBolt(t)
+	s, key := newTestServer(t, st)
+	h := s.handler()
+	for _, name := range []string{"hats", "hatstand", "socks"} {
+		if code, body := send(h, key, "PUT", "/items/"+name, `{"price": 1}`); code != http.StatusCreated {
+			t.Fatalf("create %s: %d %s", name, code, body)
+		}
+	}
+	for i, name := range []string{"hats", "socks", "hatstand", "hats"} {
+		if code, body := send(h, key, "POST", "/items/"+name+"/movements", `{"kind": "in", "quantity": 2}`); code != http.StatusCreated {
+			t.Fatalf("movement %d: %d %s", i, code, body)
+		}
+	}
+
+	// a database from before the index
+	if err := st.Tx(true, func(tx StoreTx) error {
+		var keys [][]byte
+		idx := tx.Bucket(movementIndexBucket)
+		idx.ForEach(func(k, v []byte) error {
+			keys = append(keys, append([]byte(nil), k...))
+			return nil
+		})
+		for _, k := range keys {
+			if err := idx.Delete(k); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(metaBucket).Put(schemaKey, Uint64ToBytes(5))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := prepareStore(st); err != nil {
+		t.Fatal(err)
+	}
+
+	code, body := send(h, key, "GET", "/items/hats/movements", "")
+	var got struct{ Movements []movement }
+	if err := json.Unmarshal([]byte(body), &got); code != http.StatusOK || err != nil {
+		t.Fatalf("movements: %d %s", code, body)
+	}
+	if len(got.Movements) != 2 || got.Movements[0].ID != 1 || got.Movements[1].ID != 4 || got.Movements[1].Balance != 4 {
+		t.Errorf("movements of hats: %+v; want ids 1 and 4, balance 4", got.Movements)
+	}
+}
+
+// TestOrders checks that an order takes stock for all its lines or none,
+// and that retrying it with the same Idempotency-Key does not take it again
+func TestOrders(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	h := s.handler()
+	send(h, key, "PUT", "/items/hats", `{"price": 19.99, "quantity": 3}`)
+	send(h, key, "PUT", "/items/socks", `{"price": 5, "quantity": 1}`)
+
+	const order = `{"lines": [{"item": "Hats", "quantity": 1}, {"item": "socks", "quantity": 1}, {"item": "hats", "quantity": 1}]}`
+	for _, c := range []struct {
+		key, body string
+		code      int
+		want      string
+	}{
+		{"", order, http.StatusBadRequest, "Idempotency-Key"},
+		{"a", `{"lines": [{"item": "hats", "quantity": 2}, {"item": "socks", "quantity": 2}]}`, http.StatusConflict,
+			`"shortages":[{"item":"socks","requested":2,"available":1}]`},
+		{"a", order, http.StatusCreated, `"lines":[{"item":"hats","quantity":2,"price":19.99},{"item":"socks","quantity":1,"price":5.00}],"total":44.98`},
+		{"a", order, http.StatusOK, `"total":44.98`},
+		{"a", `{"lines": [{"item": "hats", "quantity": 1}]}`, http.StatusUnprocessableEntity, "already used"},
+		{"b", `{"lines": []}`, http.StatusUnprocessableEntity, "at least one line"},
+		{"b", `{"lines": [{"item": "hats", "quantity": 0}]}`, http.StatusUnprocessableEntity, "quantity must be"},
+		{"b", `{"lines": [{"item": "gloves", "quantity": 1}]}`, http.StatusUnprocessableEntity, "no such item"},
+		{"b", `{"lines": [{"item": "hats", "quantity": 2}]}`, http.StatusConflict, "insufficient stock"},
+	} {
+		var header []string
+		if c.key != "" {
+			header = []string{"Idempotency-Key", c.key}
+		}
+		if code, body := send(h, key, "POST", "/orders", c.body, header...); code != c.code || !strings.Contains(body, c.want) {
+			t.Errorf("order %s %s: %d %s; want %d %s", c.key, c.body, code, body, c.code, c.want)
+		}
+	}
+
+	for name, want := range map[string]string{"hats": `"quantity":1`, "socks": `"quantity":0`} {
+		if _, body := send(h, key, "GET", "/items/"+name, ""); !strings.Contains(body, want) {
+			t.Errorf("%s after the order: %s; want %s", name, body, want)
+		}
+	}
+	if _, body := send(h, key, "GET", "/items/socks/movements", ""); !strings.Contains(body, `"kind":"out","quantity":1,"balance":0,"reason":"order","order":1`) {
+		t.Errorf("movements of socks: %s", body)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import "errors"
+
+// InventoryStore persists item records along with the auxiliary buckets
+// the server keeps next to them (history, movements, orders, ...).
+// The handlers only talk to this interface, so they can be exercised
+// against the in-memory store without touching db/inventory.db.
+//
+// Get, Put, Delete and List each run in their own transaction and bypass
+// the history; writes made on behalf of a client go through server.write.
+type InventoryStore interface {
+	Get(name string) (item, bool, error)
+	Put(it item) error
+	Delete(name string) error
+	List() ([]item, error) // sorted by name
+
+	// Tx runs fn in a read/write transaction if writable is set, else in a
+	// read-only one. A read/write transaction is committed if fn returns
+	// nil and rolled back otherwise. Read-only transactions see a
+	// consistent snapshot and may run concurrently.
+	Tx(writable bool, fn func(tx StoreTx) error) error
+
+	Close() error
+}
+
+// StoreTx is a transaction on an InventoryStore
+type StoreTx interface {
+	Get(name string) (item, bool, error)
+	Put(it item) error
+	Delete(name string) error
+	List() ([]item, error) // sorted by name
+
+	// Bucket returns the named bucket, or nil if it does not exist
+	Bucket(name []byte) Bucket
+	// CreateBucketIfNotExists returns the named bucket, creating it
+	// in a read/write transaction if needed
+	CreateBucketIfNotExists(name []byte) (Bucket, error)
+}
+
+// Bucket is an ordered key/value namespace within a transaction.
+// Keys and values returned by it are only valid for the life of the
+// transaction and must not be modified.
+type Bucket interface {
+	Get(key []byte) []byte
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	NextSequence() (uint64, error)
+	ForEach(fn func(k, v []byte) error) error
+	Cursor() Cursor
+}
+
+// Cursor iterates over a Bucket in key order. Each method returns nil
+// keys once the cursor moves past either end.
+type Cursor interface {
+	First() (k, v []byte)
+	Last() (k, v []byte)
+	Seek(seek []byte) (k, v []byte) // first key >= seek
+	Next() (k, v []byte)
+	Prev() (k, v []byte)
+}
+
+// priceIndexBucket holds price (8 bytes of cents) + item name -> nil, so
+// items can be paged through in price order with Cursor.Seek
+var priceIndexBucket = []byte("price_index")
+
+// errNoInventory is returned by transactions on a store whose buckets
+// have not been created by newServer
+var errNoInventory = errors.New("inventory bucket does not exist")
+
+// bucketer is implemented by the transactions of every store
+type bucketer interface {
+	Bucket(name []byte) Bucket
+}
+
+// itemIndex is a bucket of keys derived from each item, such as the price
+// index. The item helpers below keep every index in step with the
+// inventory bucket.
+type itemIndex struct {
+	bucket []byte
+	keys   func(it item) [][]byte
+}
+
+// itemIndexes are maintained on every put and delete
+var itemIndexes = []itemIndex{
+	{priceIndexBucket, func(it item) [][]byte { return [][]byte{priceIndexKey(it)} }},
+	{categoryIndexBucket, categoryIndexKeys},
+	{tagIndexBucket, tagIndexKeys},
+}
+
+// itemBuckets returns the inventory bucket and the buckets of itemIndexes
+func itemBuckets(t bucketer) (inv Bucket, idx []Bucket, err error) {
+	if inv = t.Bucket(inventoryBucket); inv == nil {
+		return nil, nil, errNoInventory
+	}
+	for _, x := range itemIndexes {
+		b := t.Bucket(x.bucket)
+		if b == nil {
+			return nil, nil, errNoInventory
+		}
+		idx = append(idx, b)
+	}
+	return inv, idx, nil
+}
+
+// reindex replaces the index entries of old, if any, with those of it,
+// if any
+func reindex(idx []Bucket, old, it *item) error {
+	for i, x := range itemIndexes {
+		if old != nil {
+			for _, k := range x.keys(*old) {
+				if err := idx[i].Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+		if it != nil {
+			for _, k := range x.keys(*it) {
+				if err := idx[i].Put(k, nil); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// priceIndexKey returns the price index key of it
+func priceIndexKey(it item) []byte {
+	return append(it.Price.bytes(), it.Name...)
+}
+
+// getItem reads and decodes the record for name
+func getItem(t bucketer, name string) (item, bool, error) {
+	inv, _, err := itemBuckets(t)
+	if err != nil {
+		return item{}, false, err
+	}
+	v := inv.Get([]byte(name))
+	if v == nil {
+		return item{}, false, nil
+	}
+	it, err := decodeItem([]byte(name), v)
+	return it, err == nil, err
+}
+
+// putItem encodes and writes it, moving its index entries
+func putItem(t bucketer, it item) error {
+	old, ok, err := getItem(t, it.Name)
+	if err != nil {
+		return err
+	}
+	inv, idx, _ := itemBuckets(t)
+	v, err := encodeItem(it)
+	if err != nil {
+		return err
+	}
+	if err := inv.Put([]byte(it.Name), v); err != nil { // serialize k,v
+		return err
+	}
+	if !ok {
+		return reindex(idx, nil, &it)
+	}
+	return reindex(idx, &old, &it)
+}
+
+// deleteItem removes the record for name and its index entries
+func deleteItem(t bucketer, name string) error {
+	old, ok, err := getItem(t, name)
+	if err != nil || !ok {
+		return err
+	}
+	inv, idx, _ := itemBuckets(t)
+	if err := reindex(idx, &old, nil); err != nil {
+		return err
+	}
+	return inv.Delete([]byte(name))
+}
+
+// listItems decodes every record, in name order
+func listItems(t bucketer) ([]item, error) {
+	inv, _, err := itemBuckets(t)
+	if err != nil {
+		return nil, err
+	}
+	var items []item
+	err = inv.ForEach(func(k, v []byte) error {
+		it, err := decodeItem(k, v)
+		if err != nil {
+			return err
+		}
+		items = append(items, it)
+		return nil
+	})
+	return items, err
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// boltStore is an InventoryStore backed by a bolt database file.
+// Transactions hold mu for reading; swapping the file underneath (restore,
+// compaction) holds it for writing, so it waits for them to finish.
+type boltStore struct {
+	path string
+	mu   sync.RWMutex // guards db
+	db   *bolt.DB
+}
+
+// openBoltStore opens (creating if needed) the bolt database at path
+func openBoltStore(path string) (*boltStore, error) {
+	db, err := openBolt(path)
+	if err != nil {
+		return nil, err
+	}
+	return &boltStore{path: path, db: db}, nil
+}
+
+// openBolt opens the bolt file at path, giving up if another process
+// holds it
+func openBolt(path string) (*bolt.DB, error) {
+	return bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+}
+
+func (s *boltStore) Get(name string) (it item, ok bool, err error) {
+	err = s.Tx(false, func(tx StoreTx) error {
+		it, ok, err = tx.Get(name)
+		return err
+	})
+	return it, ok, err
+}
+
+func (s *boltStore) Put(it item) error {
+	return s.Tx(true, func(tx StoreTx) error { return tx.Put(it) })
+}
+
+func (s *boltStore) Delete(name string) error {
+	return s.Tx(true, func(tx StoreTx) error { return tx.Delete(name) })
+}
+
+func (s *boltStore) List() (items []item, err error) {
+	err = s.Tx(false, func(tx StoreTx) error {
+		items, err = tx.List()
+		return err
+	})
+	return items, err
+}
+
+func (s *boltStore) Tx(writable bool, fn func(tx StoreTx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if writable {
+		return s.db.Update(func(tx *bolt.Tx) error { return fn(boltTx{tx}) })
+	}
+	return s.db.View(func(tx *bolt.Tx) error { return fn(boltTx{tx}) })
+}
+
+func (s *boltStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db.Close()
+}
+
+// Backup writes a consistent snapshot of the database to w. The snapshot
+// is copied from a read-only transaction to a temporary file next to the
+// database, and only then streamed from there: holding mu for as long as a
+// slow client takes to download would hold up Compact and Restore, and
+// every transaction queued behind them. size is called with the
+// snapshot's length before anything is written.
+func (s *boltStore) Backup(w io.Writer, size func(n int64)) (int64, error) {
+	f, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".backup-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	s.mu.RLock()
+	var n int64
+	err = s.db.View(func(tx *bolt.Tx) (err error) {
+		n, err = tx.WriteTo(f)
+		return err
+	})
+	s.mu.RUnlock()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	size(n)
+	return io.Copy(w, f)
+}
+
+// Restore replaces the database with the snapshot read from r. The
+// snapshot is written to a temporary file next to the database, checked
+// and migrated with prepare before the current file is touched; the swap
+// itself waits for open transactions and is undone if the new file cannot
+// be opened. The replaced file is kept as path+".prev".
+func (s *boltStore) Restore(r io.Reader, prepare func(InventoryStore) error) error {
+	tmp := s.path + ".restore"
+	if err := writeFile(tmp, r); err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if err := checkBolt(tmp, prepare); err != nil {
+		return invalidf("snapshot rejected: %v", err)
+	}
+	return s.swap(tmp)
+}
+
+// Compact rewrites the database into a new file without its free pages
+// and swaps it in. Writers are blocked while it runs. It returns the file
+// sizes before and after.
+func (s *boltStore) Compact() (before, after int64, err error) {
+	tmp := s.path + ".compact"
+	defer os.Remove(tmp)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if fi, err := os.Stat(s.path); err == nil {
+		before = fi.Size()
+	}
+	// a compaction that crashed may have left its file behind
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return before, 0, err
+	}
+	dst, err := openBolt(tmp)
+	if err != nil {
+		return before, 0, err
+	}
+	err = s.db.View(func(src *bolt.Tx) error {
+		return dst.Update(func(tx *bolt.Tx) error {
+			return src.ForEach(func(name []byte, b *bolt.Bucket) error {
+				nb, err := tx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return copyBucket(nb, b)
+			})
+		})
+	})
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return before, 0, fmt.Errorf("compaction failed: %v", err)
+	}
+	if err := s.swapLocked(tmp); err != nil {
+		return before, 0, err
+	}
+	if fi, err := os.Stat(s.path); err == nil {
+		after = fi.Size()
+	}
+	return before, after, nil
+}
+
+// copyBucket copies the keys, nested buckets and sequence of src to dst
+func copyBucket(dst, src *bolt.Bucket) error {
+	if err := dst.SetSequence(src.Sequence()); err != nil {
+		return err
+	}
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(k, v)
+		}
+		nb, err := dst.CreateBucket(k) // v is nil for a nested bucket
+		if err != nil {
+			return err
+		}
+		return copyBucket(nb, src.Bucket(k))
+	})
+}
+
+// swap replaces the database file with the bolt file at tmp
+func (s *boltStore) swap(tmp string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.swapLocked(tmp)
+}
+
+// swapLocked is swap for callers holding mu
+func (s *boltStore) swapLocked(tmp string) error {
+	prev := s.path + ".prev"
+	if err := s.db.Close(); err != nil {
+		return err
+	}
+	reopen := func() error {
+		db, err := openBolt(s.path)
+		if err == nil {
+			s.db = db
+		}
+		return err
+	}
+	if err := os.Rename(s.path, prev); err != nil {
+		if rerr := reopen(); rerr != nil {
+			return fmt.Errorf("%v; reopening database: %v", err, rerr)
+		}
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Rename(prev, s.path)
+		if rerr := reopen(); rerr != nil {
+			return fmt.Errorf("%v; reopening database: %v", err, rerr)
+		}
+		return err
+	}
+	if err := reopen(); err != nil {
+		// put the old file back rather than leave the store closed
+		os.Rename(prev, s.path)
+		if rerr := reopen(); rerr != nil {
+			return fmt.Errorf("%v; reopening previous database: %v", err, rerr)
+		}
+		return err
+	}
+	return nil
+}
+
+// checkBolt opens the bolt file at path, verifies its pages and runs
+// prepare on it
+func checkBolt(path string, prepare func(InventoryStore) error) error {
+	db, err := openBolt(path)
+	if err != nil {
+		return err
+	}
+	st := &boltStore{path: path, db: db}
+	defer st.Close()
+	if err := db.View(func(tx *bolt.Tx) (first error) {
+		for err := range tx.Check() { // drain, so the checker finishes
+			if first == nil {
+				first = err
+			}
+		}
+		if first == nil && tx.Bucket(inventoryBucket) == nil {
+			first = errNoInventory
+		}
+		return first
+	}); err != nil {
+		return err
+	}
+	return prepare(st)
+}
+
+// writeFile copies r to a new file at path, synced to disk
+func writeFile(path string, r io.Reader) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// boltTx adapts *bolt.Tx to StoreTx
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+func (t boltTx) Get(name string) (item, bool, error) { return getItem(t, name) }
+func (t boltTx) Put(it item) error                   { return putItem(t, it) }
+func (t boltTx) Delete(name string) error            { return deleteItem(t, name) }
+func (t boltTx) List() ([]item, error)               { return listItems(t) }
+
+func (t boltTx) Bucket(name []byte) Bucket {
+	b := t.tx.Bucket(name)
+	if b == nil {
+		return nil // avoid a non-nil interface holding a nil bucket
+	}
+	return boltBucket{b}
+}
+
+func (t boltTx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	b, err := t.tx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not create bucket %q: %v", name, err)
+	}
+	return boltBucket{b}, nil
+}
+
+// boltBucket adapts *bolt.Bucket to Bucket; *bolt.Cursor already
+// satisfies Cursor
+type boltBucket struct {
+	*bolt.Bucket
+}
+
+func (b boltBucket) Cursor() Cursor { return b.Bucket.Cursor() }
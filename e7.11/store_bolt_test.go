@@ -0,0 +1,89 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// openTestBolt returns a prepared bolt store in a temporary directory
+func openTestBolt(tb testing.TB) *boltStore {
+	tb.Helper()
+	st, err := openBoltStore(filepath.Join(tb.TempDir(), "inventory.db"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { st.Close() })
+	if err := prepareStore(st); err != nil {
+		tb.Fatal(err)
+	}
+	return st
+}
+
+// TestCompactStaleFile checks that compaction replaces the file left behind
+// by a compaction that crashed
+func TestCompactStaleFile(t *testing.T) {
+	st := openTestBolt(t)
+	if err := st.Put(item{Name: "hats", Price: 100, Unit: defaultUnit}); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := openBolt(st.path + ".compact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stale.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket(inventoryBucket)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	stale.Close()
+
+	if _, _, err := st.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if it, ok, err := st.Get("hats"); err != nil || !ok || it.Price != 100 {
+		t.Errorf("after compaction: %+v, %v, %v; want hats at $1.00", it, ok, err)
+	}
+}
+
+// BenchmarkBoltWrites compares opening the database for every write, as
+// the server once did, with writing through the handle the store keeps
+// open for its lifetime.
+func BenchmarkBoltWrites(b *testing.B) {
+	it := item{Name: "shirts", Unit: defaultUnit}
+
+	b.Run("open-per-write", func(b *testing.B) {
+		st := openTestBolt(b)
+		if err := st.Close(); err != nil { // bolt locks the file while open
+			b.Fatal(err)
+		}
+		path := st.path
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			st, err := openBoltStore(path)
+			if err != nil {
+				b.Fatal(err)
+			}
+			it.Price = dollars(i)
+			if err := st.Put(it); err != nil {
+				b.Fatal(err)
+			}
+			if err := st.Close(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("shared", func(b *testing.B) {
+		st := openTestBolt(b)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			it.Price = dollars(i)
+			if err := st.Put(it); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// memStore is a pure in-memory InventoryStore, used to exercise the
+// handlers without a database file. Committed buckets are never modified
+// in place: a read/write transaction works on copies of the buckets it
+// writes to and publishes them on commit, so read-only transactions keep
+// a consistent snapshot, as they do in bolt.
+type memStore struct {
+	writer  sync.Mutex   // one read/write transaction at a time
+	mu      sync.RWMutex // guards buckets and closed
+	buckets map[string]*memBucket
+	closed  bool
+}
+
+var (
+	errStoreClosed = errors.New("store closed")
+	errTxReadOnly  = errors.New("transaction not writable")
+	errKeyRequired = errors.New("key required")
+)
+
+// newMemStore returns an empty memStore
+func newMemStore() *memStore {
+	return &memStore{buckets: make(map[string]*memBucket)}
+}
+
+func (s *memStore) Get(name string) (it item, ok bool, err error) {
+	err = s.Tx(false, func(tx StoreTx) error {
+		it, ok, err = tx.Get(name)
+		return err
+	})
+	return it, ok, err
+}
+
+func (s *memStore) Put(it item) error {
+	return s.Tx(true, func(tx StoreTx) error { return tx.Put(it) })
+}
+
+func (s *memStore) Delete(name string) error {
+	return s.Tx(true, func(tx StoreTx) error { return tx.Delete(name) })
+}
+
+func (s *memStore) List() (items []item, err error) {
+	err = s.Tx(false, func(tx StoreTx) error {
+		items, err = tx.List()
+		return err
+	})
+	return items, err
+}
+
+func (s *memStore) Tx(writable bool, fn func(tx StoreTx) error) error {
+	if writable {
+		s.writer.Lock()
+		defer s.writer.Unlock()
+	}
+	s.mu.RLock()
+	snap, closed := s.buckets, s.closed
+	s.mu.RUnlock()
+	if closed {
+		return errStoreClosed
+	}
+
+	tx := &memTx{snap: snap, writable: writable, dirty: make(map[string]*memBucket)}
+	if err := fn(tx); err != nil || !writable {
+		return err // roll back by dropping the copies
+	}
+
+	// commit: publish a new bucket map holding the modified copies
+	next := make(map[string]*memBucket, len(snap)+len(tx.dirty))
+	for name, b := range snap {
+		next[name] = b
+	}
+	for name, b := range tx.dirty {
+		next[name] = b
+	}
+	s.mu.Lock()
+	s.buckets = next
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memStore) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	return nil
+}
+
+// memTx is a transaction on a memStore
+type memTx struct {
+	snap     map[string]*memBucket // committed buckets at the start of the transaction
+	dirty    map[string]*memBucket // copies modified by this transaction
+	writable bool
+}
+
+func (t *memTx) Get(name string) (item, bool, error) { return getItem(t, name) }
+func (t *memTx) Put(it item) error                   { return putItem(t, it) }
+func (t *memTx) Delete(name string) error            { return deleteItem(t, name) }
+func (t *memTx) List() ([]item, error)               { return listItems(t) }
+
+func (t *memTx) Bucket(name []byte) Bucket {
+	if t.bucket(string(name)) == nil {
+		return nil
+	}
+	return &memTxBucket{tx: t, name: string(name)}
+}
+
+func (t *memTx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	if t.bucket(string(name)) == nil {
+		if !t.writable {
+			return nil, errTxReadOnly
+		}
+		t.dirty[string(name)] = &memBucket{values: make(map[string][]byte)}
+	}
+	return &memTxBucket{tx: t, name: string(name)}, nil
+}
+
+// bucket returns the current contents of the named bucket as seen by t
+func (t *memTx) bucket(name string) *memBucket {
+	if b, ok := t.dirty[name]; ok {
+		return b
+	}
+	return t.snap[name]
+}
+
+// writable returns a private copy of the named bucket to modify
+func (t *memTx) writableBucket(name string) (*memBucket, error) {
+	if !t.writable {
+		return nil, errTxReadOnly
+	}
+	b, ok := t.dirty[name]
+	if !ok {
+		b = t.snap[name].clone()
+		t.dirty[name] = b
+	}
+	return b, nil
+}
+
+// memTxBucket is a Bucket within a memTx. Reads see the transaction's
+// own writes; the first write copies the committed bucket.
+type memTxBucket struct {
+	tx   *memTx
+	name string
+}
+
+func (b *memTxBucket) Get(key []byte) []byte {
+	return b.tx.bucket(b.name).values[string(key)]
+}
+
+func (b *memTxBucket) Put(key, value []byte) error {
+	if len(key) == 0 {
+		return errKeyRequired
+	}
+	mb, err := b.tx.writableBucket(b.name)
+	if err != nil {
+		return err
+	}
+	k := string(key)
+	if _, ok := mb.values[k]; !ok {
+		i := sort.SearchStrings(mb.keys, k)
+		mb.keys = append(mb.keys, "")
+		copy(mb.keys[i+1:], mb.keys[i:])
+		mb.keys[i] = k
+	}
+	mb.values[k] = append([]byte{}, value...)
+	return nil
+}
+
+func (b *memTxBucket) Delete(key []byte) error {
+	mb, err := b.tx.writableBucket(b.name)
+	if err != nil {
+		return err
+	}
+	k := string(key)
+	if _, ok := mb.values[k]; !ok {
+		return nil
+	}
+	delete(mb.values, k)
+	i := sort.SearchStrings(mb.keys, k)
+	mb.keys = append(mb.keys[:i], mb.keys[i+1:]...)
+	return nil
+}
+
+func (b *memTxBucket) NextSequence() (uint64, error) {
+	mb, err := b.tx.writableBucket(b.name)
+	if err != nil {
+		return 0, err
+	}
+	mb.seq++
+	return mb.seq, nil
+}
+
+func (b *memTxBucket) ForEach(fn func(k, v []byte) error) error {
+	mb := b.tx.bucket(b.name)
+	for _, k := range append([]string(nil), mb.keys...) {
+		if err := fn([]byte(k), mb.values[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *memTxBucket) Cursor() Cursor {
+	return &memCursor{b: b.tx.bucket(b.name)}
+}
+
+// memBucket is a sorted key/value map. Once committed it is shared by
+// readers and never modified.
+type memBucket struct {
+	keys   []string // sorted
+	values map[string][]byte
+	seq    uint64
+}
+
+// clone returns a copy of b; values are immutable and shared
+func (b *memBucket) clone() *memBucket {
+	c := &memBucket{
+		keys:   append([]string(nil), b.keys...),
+		values: make(map[string][]byte, len(b.values)),
+		seq:    b.seq,
+	}
+	for k, v := range b.values {
+		c.values[k] = v
+	}
+	return c
+}
+
+// memCursor iterates over a memBucket in key order
+type memCursor struct {
+	b *memBucket
+	i int
+}
+
+// at returns the entry at index i, or nils if i is out of range
+func (c *memCursor) at(i int) (k, v []byte) {
+	c.i = i
+	if i < 0 || i >= len(c.b.keys) {
+		return nil, nil
+	}
+	key := c.b.keys[i]
+	return []byte(key), c.b.values[key]
+}
+
+func (c *memCursor) First() (k, v []byte) { return c.at(0) }
+func (c *memCursor) Last() (k, v []byte)  { return c.at(len(c.b.keys) - 1) }
+func (c *memCursor) Next() (k, v []byte)  { return c.at(c.i + 1) }
+func (c *memCursor) Prev() (k, v []byte)  { return c.at(c.i - 1) }
+
+func (c *memCursor) Seek(seek []byte) (k, v []byte) {
+	return c.at(sort.SearchStrings(c.b.keys, string(seek)))
+}
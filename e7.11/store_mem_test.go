@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestHandlersOnStores runs the same requests against each store; the
+// in-memory store must behave as bolt does
+func TestHandlersOnStores(t *testing.T) {
+	calls := []struct {
+		method, path, body string
+		code               int
+		want               string
+	}{
+		{"PUT", "/items/hats", `{"price": "19.99", "quantity": 3}`, http.StatusCreated, `"price":19.99`},
+		{"PUT", "/items/hats", `{"quantity": 5}`, http.StatusOK, `"quantity":5`},
+		{"GET", "/items/hats", "", http.StatusOK, `"version":2`},
+		{"POST", "/batch", `{"ops": [{"op": "upsert", "item": "socks", "price": 5}, {"op": "delete", "item": "nope"}]}`, http.StatusUnprocessableEntity, "1 of 2 operations failed"},
+		{"GET", "/items/socks", "", http.StatusNotFound, "no such item"}, // rolled back
+		{"POST", "/batch", `{"ops": [{"op": "upsert", "item": "socks", "price": 5}]}`, http.StatusOK, `"status":201`},
+		{"GET", "/items?sort=price", "", http.StatusOK, `"name":"socks"`},
+		{"GET", "/list?sort=price", "", http.StatusOK, "socks: $5.00\nhats: $19.99\n"},
+		{"DELETE", "/items/hats", "", http.StatusNoContent, ""},
+		{"GET", "/items/hats", "", http.StatusNotFound, "no such item"},
+		{"GET", "/items/hats/history", "", http.StatusOK, `"op":"delete"`},
+		{"GET", "/list", "", http.StatusOK, "socks: $5.00\n"},
+	}
+	for name, st := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			s, key := newTestServer(t, st)
+			h := s.handler()
+			for i, c := range calls {
+				code, body := send(h, key, c.method, c.path, c.body)
+				if code != c.code || !strings.Contains(body, c.want) {
+					t.Errorf("call %d %s %s: got %d %s; want %d containing %q", i, c.method, c.path, code, body, c.code, c.want)
+				}
+			}
+		})
+	}
+}
+
+// TestMemStoreSnapshots checks that a read-only transaction keeps seeing
+// the records as they were when it began, and that a failed read/write
+// transaction leaves nothing behind
+func TestMemStoreSnapshots(t *testing.T) {
+	st := newMemStore()
+	if err := prepareStore(st); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Put(item{Name: "hats", Price: 100, Unit: defaultUnit}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := st.Tx(false, func(tx StoreTx) error {
+		if err := st.Put(item{Name: "hats", Price: 200, Unit: defaultUnit}); err != nil {
+			return err
+		}
+		it, _, err := tx.Get("hats")
+		if err == nil && it.Price != 100 {
+			t.Errorf("read transaction saw %s written after it began", it.Price)
+		}
+		if err := tx.Put(it); !errors.Is(err, errTxReadOnly) {
+			t.Errorf("write in read-only transaction: %v; want %v", err, errTxReadOnly)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failed := errors.New("failed")
+	if err := st.Tx(true, func(tx StoreTx) error {
+		if err := tx.Put(item{Name: "socks", Price: 5, Unit: defaultUnit}); err != nil {
+			return err
+		}
+		return failed
+	}); err != failed {
+		t.Fatalf("Tx: %v; want %v", err, failed)
+	}
+	items, err := st.List()
+	if err != nil || len(items) != 1 || items[0].Price != 200 {
+		t.Errorf("after rollback: %+v, %v; want hats at $2.00 only", items, err)
+	}
+}
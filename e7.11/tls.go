@@ -0,0 +1,121 @@
+/* TLS.
+With -tls-cert and -tls-key the server serves HTTPS with that certificate:
+Ex ("item_server -addr :8443 -tls-cert cert.pem -tls-key key.pem")
+For local development -self-signed makes a certificate for localhost and
+the host of -addr. If -tls-cert and -tls-key name files that exist they
+are used as is; files that do not exist are created with the new
+certificate, so it survives restarts and clients can trust it:
+Ex ("item_server -self-signed -tls-cert db/cert.pem -tls-key db/key.pem")
+Ex ("curl --cacert db/cert.pem https://localhost:8000/list")
+Without the files the certificate only lives as long as the process. */
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+// selfSignedValidity is how long a self-signed certificate is valid
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// tlsConfig returns the TLS configuration for c, or nil to serve plain HTTP
+func (c config) tlsConfig() (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+	switch {
+	case c.SelfSigned && c.TLSCert != "" && !exists(c.TLSCert) && !exists(c.TLSKey):
+		var certPEM, keyPEM []byte
+		if certPEM, keyPEM, err = selfSigned(c.Addr); err != nil {
+			return nil, err
+		}
+		if err = os.WriteFile(c.TLSKey, keyPEM, 0600); err != nil {
+			return nil, err
+		}
+		if err = os.WriteFile(c.TLSCert, certPEM, 0644); err != nil {
+			return nil, err
+		}
+		log.Printf("wrote self-signed certificate to %s", c.TLSCert)
+		cert, err = tls.X509KeyPair(certPEM, keyPEM)
+	case c.TLSCert != "":
+		cert, err = tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+	case c.SelfSigned:
+		var certPEM, keyPEM []byte
+		if certPEM, keyPEM, err = selfSigned(c.Addr); err != nil {
+			return nil, err
+		}
+		cert, err = tls.X509KeyPair(certPEM, keyPEM)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not load TLS certificate: %v", err)
+	}
+	if c.SelfSigned {
+		sum := sha256.Sum256(cert.Certificate[0])
+		log.Printf("self-signed certificate SHA-256 fingerprint: %x", sum)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// selfSigned returns a new PEM encoded certificate and private key for
+// localhost and the host of addr
+func selfSigned(addr string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"item_server development"}, CommonName: "localhost"},
+		NotBefore:             now.Add(-time.Hour), // tolerate clock skew
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true, // so clients can trust it directly, e.g. with curl --cacert
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil && host != "" && host != "localhost" {
+		if ip := net.ParseIP(host); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// exists reports whether a file exists at path
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,433 @@
+/* Bulk export and import of the inventory.
+GET  /export?format=csv|ndjson              stream every item (default csv);
+	the filters and sort of /list (see list.go) scope the export
+POST /import?format=csv|ndjson&mode=upsert  create or update the listed items
+POST /import?...&mode=replace               ... and delete every item not listed;
+	it needs at least one row, so an empty file cannot wipe the inventory
+CSV has a header row naming its columns: name (required), sku, description,
+price, quantity, reorder_level, unit, category, tags (separated by ";"). NDJSON has one
+item object per line, as exported.
+An empty or absent field leaves an existing item's value unchanged; price
+is required for new items. In replace mode an empty CSV cell instead clears
+its field (unit goes back to "each", quantity and reorder_level to 0), as
+the file is then the whole inventory; an empty price is still kept. Every row is checked before anything is stored
+and all problems are reported with their line numbers; the import is then
+applied in one transaction, so it is stored entirely or not at all. */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxImportBytes limits the size of an import body
+const maxImportBytes = 32 << 20
+
+// maxImportErrors bounds the number of row errors reported
+const maxImportErrors = 100
+
+// csvColumns are the columns written by export and accepted by import
+var csvColumns = []string{"name", "sku", "description", "price", "quantity", "reorder_level", "unit", "category", "tags"}
+
+// clearedCells holds what an empty cell stands for in a replace import;
+// name and price are never cleared
+var clearedCells = map[string]string{"sku": "", "description": "", "quantity": "0", "reorder_level": "0", "unit": defaultUnit, "category": "", "tags": ""}
+
+// importRow is one parsed row of an import
+type importRow struct {
+	Line int
+	Name string
+	itemInput
+}
+
+// rowError reports a problem with one row of an import
+type rowError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// importErrors rejects an import with one or more bad rows
+type importErrors []rowError
+
+func (e importErrors) Error() string {
+	return fmt.Sprintf("import rejected: %d rows have errors", len(e))
+}
+
+// add appends an error for line, reporting whether there is room for more
+func (e *importErrors) add(line int, format string, args ...interface{}) bool {
+	*e = append(*e, rowError{line, fmt.Sprintf(format, args...)})
+	return len(*e) < maxImportErrors
+}
+
+// importResult counts the changes made by an import
+type importResult struct {
+	Mode      string `json:"mode"`
+	Created   int    `json:"created"`
+	Updated   int    `json:"updated"`
+	Unchanged int    `json:"unchanged"`
+	Deleted   int    `json:"deleted"`
+}
+
+// Stream the inventory: GET /export
+func (s *server) export(w http.ResponseWriter, req *http.Request) {
+	lq, err := parseListQuery(req.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	lq.Limit, lq.Cursor = 0, nil // always the whole selection
+	format := req.URL.Query().Get("format")
+	var write func(it item) error
+	var flush func() error
+	var cw *csv.Writer
+	switch format {
+	case "", "csv":
+		format = "csv"
+		cw = csv.NewWriter(w)
+		write = func(it item) error {
+			return cw.Write([]string{it.Name, it.SKU, it.Description, it.Price.decimal(), strconv.FormatInt(it.Quantity, 10), strconv.FormatInt(it.Reorder, 10), it.Unit, it.Category, strings.Join(it.Tags, ";")})
+		}
+		flush = func() error { cw.Flush(); return cw.Error() }
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		write = func(it item) error { return enc.Encode(it) }
+		flush = func() error { return nil }
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	default:
+		writeError(w, http.StatusBadRequest, "format must be csv or ndjson")
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="inventory.`+format+`"`)
+	if req.Method == http.MethodHead {
+		return
+	}
+	if cw != nil {
+		cw.Write(csvColumns) // errors surface on flush
+	}
+
+	// rows are written as the bucket is walked, so the export never has
+	// to fit in memory; once streaming has started an error can only be
+	// logged and the response cut short
+	err = s.store.Tx(false, func(tx StoreTx) error {
+		return scanTx(tx, lq, func(_ []byte, it item) (bool, error) {
+			return true, write(it)
+		})
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		log.Printf("export: %v", err)
+	}
+}
+
+// Load items in bulk: POST /import
+func (s *server) importItems(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	mode := q.Get("mode")
+	switch mode {
+	case "":
+		mode = "upsert"
+	case "upsert", "replace":
+	default:
+		writeError(w, http.StatusBadRequest, "mode must be upsert or replace")
+		return
+	}
+
+	body := http.MaxBytesReader(w, req.Body, maxImportBytes)
+	var rows []importRow
+	var err error
+	switch q.Get("format") {
+	case "", "csv":
+		rows, err = parseCSVImport(body, mode == "replace")
+	case "ndjson":
+		rows, err = parseNDJSONImport(body)
+	default:
+		writeError(w, http.StatusBadRequest, "format must be csv or ndjson")
+		return
+	}
+	if err == nil {
+		var res importResult
+		res, err = s.applyImport(actorOf(req), mode, rows)
+		if err == nil {
+			writeJSON(w, http.StatusOK, res)
+			return
+		}
+	}
+	switch err := err.(type) {
+	case importErrors:
+		writeJSON(w, http.StatusUnprocessableEntity, struct {
+			apiError
+			Rows []rowError `json:"rows"`
+		}{apiError{http.StatusUnprocessableEntity, err.Error()}, err})
+	case invalidError:
+		writeError(w, http.StatusBadRequest, "%v", err)
+	default:
+		writeError(w, http.StatusInternalServerError, "import unsuccessful: %v", err)
+	}
+}
+
+// parseCSVImport reads and checks the rows of a CSV import; with clear,
+// empty cells clear their fields. Malformed input is an invalidError; bad
+// values are collected in importErrors.
+func parseCSVImport(r io.Reader, clear bool) ([]importRow, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, invalidf("empty CSV: header row required")
+	}
+	if err != nil {
+		return nil, invalidf("CSV: %v", err)
+	}
+	col := make(map[string]int)
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if !contains(csvColumns, h) {
+			return nil, invalidf("CSV: unknown column %q; columns are %s", h, strings.Join(csvColumns, ", "))
+		}
+		if _, dup := col[h]; dup {
+			return nil, invalidf("CSV: duplicate column %q", h)
+		}
+		col[h] = i
+	}
+	if _, ok := col["name"]; !ok {
+		return nil, invalidf("CSV: name column required")
+	}
+
+	var rows []importRow
+	var errs importErrors
+	seen := make(map[string]int)
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			pe, ok := err.(*csv.ParseError)
+			if !ok {
+				return nil, invalidf("CSV: %v", err) // e.g. body too large
+			}
+			if !errs.add(pe.StartLine, "%v", pe.Err) {
+				return nil, errs
+			}
+			continue
+		}
+		line, _ := cr.FieldPos(0)
+		cell := func(name string) *string {
+			i, ok := col[name]
+			if !ok {
+				return nil
+			}
+			if rec[i] != "" {
+				return &rec[i]
+			}
+			if v, ok := clearedCells[name]; ok && clear {
+				return &v
+			}
+			return nil
+		}
+		row := importRow{Line: line, itemInput: itemInput{SKU: cell("sku"), Description: cell("description"), Unit: cell("unit"), Category: cell("category")}}
+		if t := cell("tags"); t != nil {
+			tags := []string{}
+			if *t != "" {
+				tags = strings.Split(*t, ";")
+			}
+			row.Tags = &tags
+		}
+		if err := row.parse(cell("name"), cell("price"), cell("quantity"), cell("reorder_level"), seen); err != nil {
+			if !errs.add(line, "%v", err) {
+				return nil, errs
+			}
+			continue
+		}
+		rows = append(rows, row)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return rows, nil
+}
+
+// parse sets the name, price, quantity and reorder level of row from text
+// cells; seen maps the names read so far to their lines
+func (row *importRow) parse(name, price, quantity, reorder *string, seen map[string]int) error {
+	if name == nil || strings.TrimSpace(*name) == "" {
+		return fmt.Errorf("name required")
+	}
+	row.Name = canonicalName(*name)
+	if err := checkName(row.Name); err != nil {
+		return err
+	}
+	if prev, dup := seen[row.Name]; dup {
+		return fmt.Errorf("%q already appears on line %d", row.Name, prev)
+	}
+	seen[row.Name] = row.Line
+	if price != nil {
+		d, err := parseDollars(strings.TrimSpace(*price))
+		if err != nil {
+			return fmt.Errorf("price: %v", err)
+		}
+		row.Price = &d
+	}
+	if quantity != nil {
+		n, err := strconv.ParseInt(strings.TrimSpace(*quantity), 10, 64)
+		if err != nil {
+			return fmt.Errorf("quantity: not a whole number: %q", *quantity)
+		}
+		row.Quantity = &n
+	}
+	if reorder != nil {
+		n, err := strconv.ParseInt(strings.TrimSpace(*reorder), 10, 64)
+		if err != nil {
+			return fmt.Errorf("reorder_level: not a whole number: %q", *reorder)
+		}
+		row.Reorder = &n
+	}
+	return nil
+}
+
+// parseNDJSONImport reads and checks the rows of an NDJSON import
+func parseNDJSONImport(r io.Reader) ([]importRow, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, maxBodyBytes)
+	var rows []importRow
+	var errs importErrors
+	seen := make(map[string]int)
+	for line := 1; sc.Scan(); line++ {
+		b := bytes.TrimSpace(sc.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		var in struct {
+			Name *string `json:"name"`
+			itemInput
+			// written by export but maintained by the server
+			Created json.RawMessage `json:"created"`
+			Updated json.RawMessage `json:"updated"`
+			Version json.RawMessage `json:"version"`
+		}
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err := dec.Decode(&in)
+		row := importRow{Line: line, itemInput: in.itemInput}
+		if err == nil {
+			err = row.parse(in.Name, nil, nil, nil, seen)
+		}
+		if err != nil {
+			if !errs.add(line, "%v", err) {
+				return nil, errs
+			}
+			continue
+		}
+		rows = append(rows, row)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, invalidf("NDJSON: %v", err)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return rows, nil
+}
+
+// applyImport stores rows in one transaction on behalf of actor. In
+// replace mode items not in rows are deleted, so there must be some rows.
+// Rows that fail validation against the stored items are reported as
+// importErrors and nothing is stored.
+func (s *server) applyImport(actor, mode string, rows []importRow) (res importResult, err error) {
+	if mode == "replace" && len(rows) == 0 {
+		return res, invalidf("replace import has no rows; it would delete every item")
+	}
+	err = s.write(actor, func(w *writeTx) error {
+		res = importResult{Mode: mode}
+		var errs importErrors
+		for _, row := range rows {
+			old, ok, err := w.get(row.Name)
+			if err != nil {
+				return err
+			}
+			it := old
+			if !ok {
+				if row.Price == nil {
+					if !errs.add(row.Line, "price required for new item %q", row.Name) {
+						return errs
+					}
+					continue
+				}
+				it = item{Name: row.Name, Unit: defaultUnit}
+			}
+			row.apply(&it)
+			if err := it.normalize(); err != nil {
+				if !errs.add(row.Line, "%v", err) {
+					return errs
+				}
+				continue
+			}
+			if ok && it.equal(old) {
+				res.Unchanged++
+				continue
+			}
+			if err := w.put(it); err != nil {
+				if _, invalid := err.(invalidError); !invalid {
+					return err
+				}
+				if !errs.add(row.Line, "%v", err) {
+					return errs
+				}
+				continue
+			}
+			if ok {
+				res.Updated++
+			} else {
+				res.Created++
+			}
+		}
+		if len(errs) > 0 {
+			return errs
+		}
+
+		if mode == "replace" {
+			keep := make(map[string]bool, len(rows))
+			for _, row := range rows {
+				keep[row.Name] = true
+			}
+			var drop []string
+			if err := w.tx.Bucket(inventoryBucket).ForEach(func(k, _ []byte) error {
+				if !keep[string(k)] {
+					drop = append(drop, string(k))
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			for _, name := range drop {
+				if err := w.delete(name); err != nil {
+					return err
+				}
+				res.Deleted++
+			}
+		}
+		return nil
+	})
+	return res, err
+}
+
+// contains reports whether list holds s
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
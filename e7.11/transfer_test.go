@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestImportExport checks that an import is checked row by row and stored
+// whole or not at all, and that export writes what import reads
+func TestImportExport(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	h := s.handler()
+	for _, c := range []struct {
+		method, target, body string
+		code                 int
+		want                 string
+	}{
+		{"POST", "/import", "name,price,quantity\nHats,1.50,3\nsocks,2,\n", http.StatusOK, `"created":2`},
+		{"POST", "/import", "name,price,quantity\nhats,1.50,3\n", http.StatusOK, `"unchanged":1`},
+		{"POST", "/import", "name,price\nhats,x\nhats,2\n,3\nnew,\n", http.StatusUnprocessableEntity, `{"line":2,"error":"price`},
+		{"POST", "/import", "name,price\nhats,x\nhats,2\n,3\nnew,\n", http.StatusUnprocessableEntity, `{"line":4,"error":"name required"}`},
+		{"POST", "/import", "name,price\nnew,\n", http.StatusUnprocessableEntity, `{"line":2,"error":"price required for new item \"new\""}`},
+		{"POST", "/import", "name,bogus\n", http.StatusBadRequest, "unknown column"},
+		{"POST", "/import?mode=merge", "name,price\n", http.StatusBadRequest, "mode must be"},
+		{"GET", "/export", "", http.StatusOK, "name,sku,description,price,quantity,reorder_level,unit,category,tags\nhats,,,1.50,3,0,each,,\nsocks,,,2.00,0,0,each,,\n"},
+		{"GET", "/export?format=ndjson", "", http.StatusOK, `"name":"socks"`},
+		{"GET", "/export?format=xml", "", http.StatusBadRequest, "format must be"},
+		{"POST", "/import?format=ndjson&mode=replace", `{"name": "socks", "price": 3}` + "\n", http.StatusOK, `"deleted":1`},
+		{"POST", "/import?format=ndjson", `{"name": "socks", "pirce": 3}` + "\n", http.StatusUnprocessableEntity, `"line":1`},
+		{"GET", "/list", "", http.StatusOK, "socks: $3.00\n"},
+	} {
+		if code, body := send(h, key, c.method, c.target, c.body); code != c.code || !strings.Contains(body, c.want) {
+			t.Errorf("%s %s %q: %d %s; want %d containing %q", c.method, c.target, c.body, code, body, c.code, c.want)
+		}
+	}
+
+	// an export imports back unchanged
+	_, csv := send(h, key, "GET", "/export", "")
+	if code, body := send(h, key, "POST", "/import?mode=replace", csv); code != http.StatusOK || !strings.Contains(body, `"unchanged":1`) {
+		t.Errorf("import of the export: %d %s", code, body)
+	}
+}
+
+// TestReplaceImport checks that a replace import deletes the items it does
+// not list, but refuses to run without any rows
+func TestReplaceImport(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	h := s.handler()
+	for _, name := range []string{"hats", "socks"} {
+		if code, body := send(h, key, "PUT", "/items/"+name, `{"price": 1}`); code != http.StatusCreated {
+			t.Fatalf("create %s: %d %s", name, code, body)
+		}
+	}
+
+	for _, c := range []struct {
+		query, body string
+		code        int
+		want        string
+	}{
+		{"format=csv&mode=replace", "name,price\n", http.StatusBadRequest, "no rows"},
+		{"format=ndjson&mode=replace", "", http.StatusBadRequest, "no rows"},
+		{"format=csv&mode=upsert", "name,price\n", http.StatusOK, `"created":0`},
+		{"format=csv&mode=replace", "name,price\nhats,2\n", http.StatusOK, `"deleted":1`},
+	} {
+		if code, body := send(h, key, "POST", "/import?"+c.query, c.body); code != c.code || !strings.Contains(body, c.want) {
+			t.Errorf("import %s %q: %d %s; want %d containing %q", c.query, c.body, code, body, c.code, c.want)
+		}
+	}
+	if code, body := send(h, key, "GET", "/list", ""); body != "hats: $2.00\n" {
+		t.Errorf("after imports: %d %q; want only hats at $2.00", code, body)
+	}
+}
+
+// TestReplaceImportClears checks that an empty cell clears its field in a
+// replace import but leaves it alone in an upsert
+func TestReplaceImportClears(t *testing.T) {
+	s, key := newTestServer(t, newMemStore())
+	h := s.handler()
+	full := `{"price": 1, "quantity": 4, "unit": "box", "description": "felt", "category": "clothing", "tags": ["sale"]}`
+	if code, body := send(h, key, "PUT", "/items/hats", full); code != http.StatusCreated {
+		t.Fatalf("create: %d %s", code, body)
+	}
+	const csv = "name,price,quantity,unit,description,category,tags\nhats,,,,,,\n"
+
+	if code, body := send(h, key, "POST", "/import?format=csv&mode=upsert", csv); code != http.StatusOK || !strings.Contains(body, `"unchanged":1`) {
+		t.Fatalf("upsert: %d %s", code, body)
+	}
+	if code, body := send(h, key, "POST", "/import?format=csv&mode=replace", csv); code != http.StatusOK || !strings.Contains(body, `"updated":1`) {
+		t.Fatalf("replace: %d %s", code, body)
+	}
+	code, body := send(h, key, "GET", "/items/hats", "")
+	for _, want := range []string{`"price":1.00`, `"quantity":0`, `"unit":"each"`, `"description":""`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("after replace: %d %s; want %s", code, body, want)
+		}
+	}
+	if strings.Contains(body, `"category"`) || strings.Contains(body, `"tags"`) {
+		t.Errorf("after replace: %s; want no category or tags", body)
+	}
+}
@@ -0,0 +1,140 @@
+/* Low-stock alerts.
+With -low-stock-webhook URL the server POSTs a JSON alert to URL whenever a
+committed change takes an item's quantity to or below its reorder_level
+from above it (or creates it there):
+	{"event": "low_stock", "item": "shirts", "quantity": 3,
+	 "reorder_level": 5, "seq": 42, "time": "2024-07-01T12:00:00Z"}
+An item that stays low does not alert again until it is restocked above
+its level. Alerts are sent in order by one goroutine, so a slow receiver
+never holds up writes; a failed delivery is retried with exponential
+backoff, and given up after maxAlertAttempts or on a 4xx other than 408 or
+429. seq is the history sequence of the change (see history.go), which a
+receiver may use to drop duplicates. Followers send no alerts. */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Delivery limits for alerts
+const (
+	alertQueueSize   = 1000
+	maxAlertAttempts = 8
+	alertTimeout     = 10 * time.Second
+	minAlertBackoff  = time.Second
+	maxAlertBackoff  = time.Minute
+)
+
+// alert is the body of a low-stock webhook request
+type alert struct {
+	Event    string    `json:"event"`
+	Item     string    `json:"item"`
+	Quantity int64     `json:"quantity"`
+	Reorder  int64     `json:"reorder_level"`
+	Seq      uint64    `json:"seq"`
+	Time     time.Time `json:"time"`
+}
+
+// webhook delivers alerts to one URL
+type webhook struct {
+	url    string
+	client *http.Client
+	queue  chan alert
+}
+
+// newWebhook returns a webhook for the http or https URL u
+func newWebhook(u string) (*webhook, error) {
+	p, err := url.Parse(u)
+	if err != nil || (p.Scheme != "http" && p.Scheme != "https") || p.Host == "" {
+		return nil, fmt.Errorf("webhook URL must be an http or https URL: %q", u)
+	}
+	return &webhook{
+		url:    u,
+		client: &http.Client{Timeout: alertTimeout},
+		queue:  make(chan alert, alertQueueSize),
+	}, nil
+}
+
+// lowStockAlerts queues an alert for each change that takes an item to or
+// below its reorder level. It never blocks: alerts that do not fit in the
+// queue are dropped and logged.
+func (h *webhook) lowStockAlerts(changes []change) {
+	for _, c := range changes {
+		if c.New == nil || !c.New.lowStock() || c.Old != nil && c.Old.lowStock() {
+			continue
+		}
+		a := alert{"low_stock", c.Item, c.New.Quantity, c.New.Reorder, c.Seq, c.Time}
+		select {
+		case h.queue <- a:
+		default:
+			log.Printf("low-stock webhook: queue full, dropped alert for %q (seq %d)", a.Item, a.Seq)
+		}
+	}
+}
+
+// run delivers queued alerts until ctx is done
+func (h *webhook) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case a := <-h.queue:
+			if err := h.deliver(ctx, a); err != nil {
+				log.Printf("low-stock webhook: gave up on alert for %q (seq %d): %v", a.Item, a.Seq, err)
+			}
+		}
+	}
+}
+
+// deliver posts a, retrying failures with exponential backoff
+func (h *webhook) deliver(ctx context.Context, a alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	backoff := minAlertBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := h.post(ctx, body)
+		if err == nil || !retry || attempt == maxAlertAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxAlertBackoff {
+			backoff = maxAlertBackoff
+		}
+	}
+}
+
+// post sends one request, reporting whether a failure is worth retrying
+func (h *webhook) post(ctx context.Context, body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	switch code := resp.StatusCode; {
+	case code < 300:
+		return false, nil
+	case code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500:
+		return true, fmt.Errorf("webhook returned %s", resp.Status)
+	default:
+		return false, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestLowStockWebhook checks that an alert is sent once each time an item
+// falls to its reorder level, and that a 5xx is retried
+func TestLowStockWebhook(t *testing.T) {
+	var mu sync.Mutex
+	var codes []int
+	alerts := make(chan alert, 10)
+	rcv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		code := http.StatusOK
+		if len(codes) == 0 {
+			code = http.StatusServiceUnavailable
+		}
+		codes = append(codes, code)
+		if code == http.StatusOK {
+			var a alert
+			if err := json.NewDecoder(req.Body).Decode(&a); err != nil {
+				t.Errorf("alert body: %v", err)
+			}
+			alerts <- a
+		}
+		w.WriteHeader(code)
+	}))
+	defer rcv.Close()
+
+	s, key := newTestServer(t, newMemStore())
+	var err error
+	if s.lowStock, err = newWebhook(rcv.URL); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.lowStock.run(ctx)
+
+	h := s.handler()
+	for _, q := range []int{10, 4, 3, 8, 2} { // falls to 5 or below twice
+		body := fmt.Sprintf(`{"price": 1, "quantity": %d, "reorder_level": 5}`, q)
+		if code, resp := send(h, key, "PUT", "/items/hats", body); code >= 300 {
+			t.Fatalf("PUT quantity %d: %d %s", q, code, resp)
+		}
+	}
+
+	for _, want := range []int64{4, 2} {
+		select {
+		case a := <-alerts:
+			if a.Event != "low_stock" || a.Item != "hats" || a.Quantity != want || a.Reorder != 5 {
+				t.Errorf("alert %+v; want hats at %d", a, want)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("no alert for quantity %d", want)
+		}
+	}
+	select {
+	case a := <-alerts:
+		t.Errorf("unexpected alert %+v", a)
+	case <-time.After(100 * time.Millisecond):
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(codes) != 3 || codes[0] != http.StatusServiceUnavailable {
+		t.Errorf("receiver answered %v; want one 503, then the retry and the second alert", codes)
+	}
+}
+
+// TestWebhookGivesUp checks that a 4xx is not retried
+func TestWebhookGivesUp(t *testing.T) {
+	var n int
+	rcv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n++
+		io.Copy(io.Discard, req.Body)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer rcv.Close()
+	h, err := newWebhook(rcv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.deliver(context.Background(), alert{Event: "low_stock", Item: "hats"}); err == nil || n != 1 {
+		t.Errorf("deliver: %v after %d requests; want an error after 1", err, n)
+	}
+}
+
+// TestWebhookQueueBound checks that alerts beyond the queue are dropped
+// rather than holding up the write that caused them
+func TestWebhookQueueBound(t *testing.T) {
+	h, err := newWebhook("http://localhost/alerts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	low := &item{Name: "hats", Quantity: 1, Reorder: 5}
+	changes := make([]change, alertQueueSize+10)
+	for i := range changes {
+		changes[i] = change{Seq: uint64(i + 1), Item: "hats", New: low}
+	}
+	h.lowStockAlerts(changes) // must not block
+	if len(h.queue) != alertQueueSize {
+		t.Errorf("queued %d alerts; want %d", len(h.queue), alertQueueSize)
+	}
+	if _, err := newWebhook("ftp://localhost/alerts"); err == nil {
+		t.Error("newWebhook accepted an ftp URL")
+	}
+}